@@ -0,0 +1,99 @@
+package oauthdialog
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+const (
+	clientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+	// Lifetime of a client assertion, only used once right away.
+	clientAssertionMaxAge = 5 * time.Minute
+)
+
+// ErrUnsupportedAlgorithm is returned when the algorithm set with
+// WithPrivateKeyJWT isn't supported or doesn't match the key.
+var ErrUnsupportedAlgorithm = errors.New("Unsupported signing algorithm")
+
+// A key signing client assertions.
+type clientKey struct {
+	signer crypto.Signer
+	alg    string
+	kid    string
+}
+
+// Get a function signing JWTs with the key, as defined in RFC 7518.
+func (k *clientKey) sign() (func([]byte) ([]byte, error), error) {
+	switch k.alg {
+	case "RS256", "PS256":
+		if _, ok := k.signer.Public().(*rsa.PublicKey); !ok {
+			return nil, fmt.Errorf("%w: %v needs an RSA key", ErrUnsupportedAlgorithm, k.alg)
+		}
+		var opts crypto.SignerOpts = crypto.SHA256
+		if k.alg == "PS256" {
+			opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}
+		}
+		return func(input []byte) ([]byte, error) {
+			digest := sha256.Sum256(input)
+			return k.signer.Sign(rand.Reader, digest[:], opts)
+		}, nil
+	case "ES256":
+		if pub, ok := k.signer.Public().(*ecdsa.PublicKey); !ok || pub.Curve.Params().BitSize != 256 {
+			return nil, fmt.Errorf("%w: ES256 needs a P-256 key", ErrUnsupportedAlgorithm)
+		}
+		return func(input []byte) ([]byte, error) {
+			digest := sha256.Sum256(input)
+			der, err := k.signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+			if err != nil {
+				return nil, err
+			}
+			// JWS wants r and s concatenated rather than ASN.1
+			var sig struct{ R, S *big.Int }
+			if _, err := asn1.Unmarshal(der, &sig); err != nil {
+				return nil, err
+			}
+			b := make([]byte, 64)
+			sig.R.FillBytes(b[:32])
+			sig.S.FillBytes(b[32:])
+			return b, nil
+		}, nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedAlgorithm, k.alg)
+	}
+}
+
+// Build a client assertion for the private_key_jwt client authentication
+// method defined in OpenID Connect Core section 9, for the endpoint aud.
+func (d *Dialog) clientAssertion(aud string) (string, error) {
+	sign, err := d.clientKey.sign()
+	if err != nil {
+		return "", err
+	}
+	jti, err := randomString(stateLength)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss": d.config.ClientID,
+		"sub": d.config.ClientID,
+		"aud": aud,
+		"jti": jti,
+		"iat": now.Unix(),
+		"exp": now.Add(clientAssertionMaxAge).Unix(),
+	}
+	header := map[string]interface{}{"alg": d.clientKey.alg, "typ": "JWT"}
+	if d.clientKey.kid != "" {
+		header["kid"] = d.clientKey.kid
+	}
+	return encodeJWT(header, claims, sign)
+}
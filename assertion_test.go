@@ -0,0 +1,114 @@
+package oauthdialog
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Check the signature of the JWT jwt with the public key of key, and get its
+// header and claims.
+func verifyJWT(t *testing.T, jwt string, key crypto.Signer, alg string) (header, claims map[string]interface{}) {
+	t.Helper()
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		t.Fatalf("malformed JWT %q", jwt)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch alg {
+	case "RS256":
+		err = rsa.VerifyPKCS1v15(key.Public().(*rsa.PublicKey), crypto.SHA256, digest[:], sig)
+	case "PS256":
+		err = rsa.VerifyPSS(key.Public().(*rsa.PublicKey), crypto.SHA256, digest[:], sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	case "ES256":
+		if len(sig) != 64 {
+			err = errors.New("ECDSA signature isn't 64 bytes")
+			break
+		}
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(key.Public().(*ecdsa.PublicKey), digest[:], r, s) {
+			err = errors.New("invalid ECDSA signature")
+		}
+	}
+	if err != nil {
+		t.Fatalf("%v signature: %v", alg, err)
+	}
+
+	for i, v := range []*map[string]interface{}{&header, &claims} {
+		b, err := base64.RawURLEncoding.DecodeString(parts[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(b, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return header, claims
+}
+
+func TestWithPrivateKeyJWT(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for alg, key := range map[string]crypto.Signer{"RS256": rsaKey, "PS256": rsaKey, "ES256": ecKey} {
+		srv, form := tokenServer(t, `{"access_token":"access","token_type":"bearer"}`)
+		conf := testConfig()
+		conf.ClientSecret = "secret"
+		conf.Endpoint.TokenURL = srv.URL
+		d := New(conf, WithPrivateKeyJWT(key, alg, "key-1"))
+		if _, err := d.Exchange(context.Background(), "code"); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := form.Get("client_assertion_type"); got != clientAssertionType {
+			t.Errorf("%v: got assertion type %q", alg, got)
+		}
+		if _, ok := (*form)["client_secret"]; ok {
+			t.Errorf("%v: client secret sent along the assertion", alg)
+		}
+		header, claims := verifyJWT(t, form.Get("client_assertion"), key, alg)
+		if header["alg"] != alg || header["kid"] != "key-1" {
+			t.Errorf("%v: got header %v", alg, header)
+		}
+		if claims["iss"] != "id" || claims["sub"] != "id" || claims["aud"] != srv.URL || claims["jti"] == "" {
+			t.Errorf("%v: got claims %v", alg, claims)
+		}
+		exp, _ := claims["exp"].(float64)
+		if remaining := time.Until(time.Unix(int64(exp), 0)); remaining <= 0 || remaining > clientAssertionMaxAge {
+			t.Errorf("%v: assertion expires in %v", alg, remaining)
+		}
+	}
+}
+
+func TestWithPrivateKeyJWTMismatch(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, alg := range []string{"RS256", "HS256"} {
+		d := New(testConfig(), WithPrivateKeyJWT(ecKey, alg, ""))
+		if _, err := d.Exchange(context.Background(), "code"); !errors.Is(err, ErrUnsupportedAlgorithm) {
+			t.Errorf("got error %v for %v, want ErrUnsupportedAlgorithm", err, alg)
+		}
+	}
+}
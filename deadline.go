@@ -0,0 +1,140 @@
+package oauthdialog
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Default limit of the success and error handlers, see WithHandlerTimeout.
+const defaultHandlerTimeout = 5 * time.Second
+
+// A response writer buffering the response of a handler, so that it can be
+// dropped if the handler doesn't finish in time. Flushing commits the
+// response: what was buffered is sent and later writes go straight to w.
+type bufferedResponse struct {
+	w      http.ResponseWriter
+	header http.Header
+
+	mu        sync.Mutex
+	status    int
+	body      bytes.Buffer
+	committed bool
+	timedOut  bool
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	if b.committed {
+		return b.w.Write(p)
+	}
+	return b.body.Write(p)
+}
+
+// Send what was buffered so far and flush it, unless the fallback is
+// already being served.
+func (b *bufferedResponse) Flush() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.timedOut {
+		return
+	}
+	b.commit()
+	if f, ok := b.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Send the buffered response to w, if not already done. b.mu must be held.
+func (b *bufferedResponse) commit() {
+	if b.committed {
+		return
+	}
+	b.committed = true
+	for name, values := range b.header {
+		b.w.Header()[name] = values
+	}
+	if b.status != 0 {
+		b.w.WriteHeader(b.status)
+	}
+	b.w.Write(b.body.Bytes())
+	b.body.Reset()
+}
+
+// Mark the response as timed out, unless it was committed already.
+func (b *bufferedResponse) timeout() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.committed {
+		return false
+	}
+	b.timedOut = true
+	return true
+}
+
+// Serve req with h, or with fallback if h doesn't finish within the time set
+// with WithHandlerTimeout. The context of the request passed to h is done
+// once the time is up. If h flushed its response by then, it is kept and h
+// may still finish it.
+func (d *Dialog) serveBounded(w http.ResponseWriter, req *http.Request, h, fallback http.HandlerFunc) {
+	if d.handlerTimeout <= 0 {
+		h(w, req)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(req.Context())
+	defer cancel()
+	buf := &bufferedResponse{w: w, header: make(http.Header)}
+	done := make(chan interface{}, 1)
+	go func() {
+		// Panics are raised again by the serving goroutine, for the server
+		// to recover as usual
+		defer func() { done <- recover() }()
+		h(buf, req.WithContext(ctx))
+	}()
+	finish := func(p interface{}) {
+		if p != nil {
+			panic(p)
+		}
+		buf.mu.Lock()
+		buf.commit()
+		buf.mu.Unlock()
+	}
+
+	timer := newTimer(d.handlerTimeout)
+	defer timer.Stop()
+	select {
+	case p := <-done:
+		finish(p)
+	case <-timer.C:
+		if !buf.timeout() {
+			// The response is under way, let h end it
+			cancel()
+			finish(<-done)
+			return
+		}
+		d.logf("oauthdialog: handler still running after %v, serving the default page", d.handlerTimeout)
+		fallback(w, req)
+	}
+}
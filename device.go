@@ -0,0 +1,203 @@
+package oauthdialog
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/oauth2"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	deviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+	// Default polling interval defined in RFC 8628 section 3.2.
+	defaultDeviceInterval = 5 * time.Second
+	// Added to the polling interval on slow_down, see RFC 8628 section 3.5.
+	deviceSlowDownStep = 5 * time.Second
+)
+
+var (
+	// ErrNoDeviceAuthURL is returned by DeviceFlowContext when no device
+	// authorization endpoint is configured.
+	ErrNoDeviceAuthURL = errors.New("No device authorization endpoint")
+	// ErrExpiredToken is the device authorization error defined in RFC 8628
+	// section 3.5.
+	ErrExpiredToken = errors.New("Device code expired")
+)
+
+// A device authorization response, as defined in RFC 8628 section 3.2.
+type DeviceAuth struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete,omitempty"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval,omitempty"`
+}
+
+// A function presenting a device authorization to the user, e.g. by printing
+// the verification URI and user code or rendering a QR code of
+// VerificationURIComplete.
+type DeviceDisplayFunc func(auth *DeviceAuth) error
+
+func defaultDeviceDisplay(auth *DeviceAuth) error {
+	_, err := fmt.Fprintf(os.Stderr, "To sign in, visit %v and enter the code %v\n", auth.VerificationURI, auth.UserCode)
+	return err
+}
+
+// Get the HTTP client to use for back-channel requests, following the
+// oauth2 package convention.
+func httpClient(ctx context.Context) *http.Client {
+	if c, ok := ctx.Value(oauth2.HTTPClient).(*http.Client); ok {
+		return c
+	}
+	return http.DefaultClient
+}
+
+// POST a form to an OAuth2 endpoint and decode the JSON response into v. Error
+// responses are returned as an *OAuthError when possible.
+func postForm(ctx context.Context, endpoint string, form url.Values, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := httpClient(ctx).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var errResp struct {
+			Error            string `json:"error"`
+			ErrorDescription string `json:"error_description"`
+			ErrorURI         string `json:"error_uri"`
+		}
+		if json.Unmarshal(body, &errResp) == nil && errResp.Error != "" {
+			return &OAuthError{
+				Code:        errResp.Error,
+				Description: errResp.ErrorDescription,
+				URI:         errResp.ErrorURI,
+			}
+		}
+		return fmt.Errorf("Unexpected status %v: %v", resp.StatusCode, redactBody(body))
+	}
+
+	return json.Unmarshal(body, v)
+}
+
+// Parse a token endpoint response, keeping all fields as extras.
+func parseToken(raw map[string]interface{}) (*oauth2.Token, error) {
+	tok := &oauth2.Token{}
+	tok.AccessToken, _ = raw["access_token"].(string)
+	tok.TokenType, _ = raw["token_type"].(string)
+	tok.RefreshToken, _ = raw["refresh_token"].(string)
+	if tok.AccessToken == "" {
+		return nil, errors.New("Server response missing access_token")
+	}
+	if expiresIn, ok := raw["expires_in"].(float64); ok && expiresIn > 0 {
+		tok.Expiry = time.Now().Add(time.Duration(expiresIn) * time.Second)
+	}
+	return tok.WithExtra(raw), nil
+}
+
+// Run the device authorization grant defined in RFC 8628: request a device
+// code, present it to the user with the function set by WithDeviceDisplay and
+// poll the token endpoint until the user authorizes the device, ctx is done
+// or the device code expires.
+//
+// The device authorization endpoint must be set with WithDeviceAuthURL.
+// Provider errors, such as ErrAccessDenied or ErrExpiredToken, are returned
+// as an *OAuthError.
+func (d *Dialog) DeviceFlowContext(ctx context.Context) (*oauth2.Token, error) {
+	if d.config == nil {
+		return nil, ErrNilConfig
+	}
+	if d.deviceAuthURL == "" {
+		return nil, ErrNoDeviceAuthURL
+	}
+
+	conf := d.flowConfig()
+	form := url.Values{"client_id": {conf.ClientID}}
+	if len(conf.Scopes) > 0 {
+		form.Set("scope", strings.Join(conf.Scopes, " "))
+	}
+
+	var auth DeviceAuth
+	if err := postForm(ctx, d.deviceAuthURL, form, &auth); err != nil {
+		return nil, err
+	}
+
+	display := d.deviceDisplay
+	if display == nil {
+		display = defaultDeviceDisplay
+	}
+	if err := display(&auth); err != nil {
+		return nil, err
+	}
+
+	interval := defaultDeviceInterval
+	if auth.Interval > 0 {
+		interval = time.Duration(auth.Interval) * time.Second
+	}
+	if auth.ExpiresIn > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(auth.ExpiresIn)*time.Second)
+		defer cancel()
+	}
+
+	form = url.Values{
+		"grant_type":  {deviceCodeGrantType},
+		"device_code": {auth.DeviceCode},
+		"client_id":   {conf.ClientID},
+	}
+	if conf.ClientSecret != "" {
+		form.Set("client_secret", conf.ClientSecret)
+	}
+
+	for {
+		timer := newTimer(interval)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) && auth.ExpiresIn > 0 {
+				return nil, &OAuthError{Code: "expired_token"}
+			}
+			return nil, ctx.Err()
+		}
+
+		var raw map[string]interface{}
+		err := postForm(ctx, conf.Endpoint.TokenURL, form, &raw)
+		var oauthErr *OAuthError
+		if errors.As(err, &oauthErr) {
+			switch oauthErr.Code {
+			case "authorization_pending":
+				continue
+			case "slow_down":
+				interval += deviceSlowDownStep
+				continue
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return parseToken(raw)
+	}
+}
@@ -0,0 +1,113 @@
+package oauthdialog
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Start a provider serving a device authorization at /device and the given
+// token responses at /token, one per poll, repeating the last one. The
+// polling intervals are recorded and the poll timers expire at once.
+func deviceServer(t *testing.T, expiresIn int, responses ...string) (*Dialog, func() []time.Duration) {
+	var mu sync.Mutex
+	var intervals []time.Duration
+	t.Cleanup(func() { newTimer = time.NewTimer })
+	newTimer = func(d time.Duration) *time.Timer {
+		mu.Lock()
+		intervals = append(intervals, d)
+		mu.Unlock()
+		return time.NewTimer(0)
+	}
+
+	polls := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/device", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&DeviceAuth{
+			DeviceCode:      "device",
+			UserCode:        "USER-CODE",
+			VerificationURI: "https://provider.example/device",
+			ExpiresIn:       expiresIn,
+			Interval:        1,
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.PostForm.Get("grant_type") != deviceCodeGrantType || r.PostForm.Get("device_code") != "device" {
+			t.Errorf("got token request %v", r.PostForm)
+		}
+		body := responses[len(responses)-1]
+		if polls < len(responses) {
+			body = responses[polls]
+		}
+		polls++
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasPrefix(body, `{"error"`) {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		w.Write([]byte(body))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	conf := testConfig()
+	conf.Endpoint.TokenURL = srv.URL + "/token"
+	d := New(conf,
+		WithDeviceAuthURL(srv.URL+"/device"),
+		WithDeviceDisplay(func(auth *DeviceAuth) error { return nil }),
+	)
+	return d, func() []time.Duration {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]time.Duration(nil), intervals...)
+	}
+}
+
+func TestDeviceFlowPolling(t *testing.T) {
+	d, intervals := deviceServer(t, 0,
+		`{"error":"authorization_pending"}`,
+		`{"error":"slow_down"}`,
+		`{"error":"authorization_pending"}`,
+		`{"access_token":"access","token_type":"bearer"}`,
+	)
+	tok, err := d.DeviceFlowContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.AccessToken != "access" {
+		t.Errorf("got access token %q, want %q", tok.AccessToken, "access")
+	}
+	want := []time.Duration{time.Second, time.Second, time.Second + deviceSlowDownStep, time.Second + deviceSlowDownStep}
+	if got := intervals(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got polling intervals %v, want %v", got, want)
+	}
+}
+
+func TestDeviceFlowExpiredToken(t *testing.T) {
+	d, _ := deviceServer(t, 0,
+		`{"error":"authorization_pending"}`,
+		`{"error":"expired_token"}`,
+	)
+	_, err := d.DeviceFlowContext(context.Background())
+	var oauthErr *OAuthError
+	if !errors.Is(err, ErrExpiredToken) || !errors.As(err, &oauthErr) {
+		t.Errorf("got error %v, want an *OAuthError matching ErrExpiredToken", err)
+	}
+}
+
+func TestDeviceFlowExpiresIn(t *testing.T) {
+	d, _ := deviceServer(t, 1, `{"error":"authorization_pending"}`)
+	newTimer = func(d time.Duration) *time.Timer { return time.NewTimer(time.Hour) }
+	_, err := d.DeviceFlowContext(context.Background())
+	if !errors.Is(err, ErrExpiredToken) {
+		t.Errorf("got error %v, want ErrExpiredToken", err)
+	}
+}
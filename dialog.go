@@ -2,11 +2,19 @@
 package oauthdialog
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
-	"github.com/skratchdot/open-golang/open"
+	"fmt"
 	"golang.org/x/oauth2"
+	"io"
+	"log"
 	"net"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
 )
 
 // OAuth2 errors defined in RFC 6749 section 4.1.2.1.
@@ -20,6 +28,10 @@ var (
 	ErrTemporarilyUnavailable  = errors.New("Temporarily unavailable")
 )
 
+// Default time given to a callback being delivered when the dialog is
+// stopped.
+const defaultShutdownGrace = 300 * time.Millisecond
+
 var errorsByName = map[string]error{
 	"invalid_request":           ErrInvalidRequest,
 	"unauthorized_client":       ErrUnauthorizedClient,
@@ -28,121 +40,1239 @@ var errorsByName = map[string]error{
 	"invalid_scope":             ErrInvalidScope,
 	"server_error":              ErrServerError,
 	"temporarily_unavailable":   ErrTemporarilyUnavailable,
+	"expired_token":             ErrExpiredToken,
+
+	"interaction_required":       ErrInteractionRequired,
+	"login_required":             ErrLoginRequired,
+	"account_selection_required": ErrAccountSelectionRequired,
+	"consent_required":           ErrConsentRequired,
+}
+
+// ErrSoftError is matched by provider errors whose code was marked as
+// expected with WithSoftErrors.
+var ErrSoftError = errors.New("Non-fatal provider error")
+
+// An error returned by the provider, as defined in RFC 6749 section
+// 4.1.2.1. errors.Is reports whether it matches one of the errors above, or
+// ErrSoftError.
+type OAuthError struct {
+	Code        string
+	Description string
+	URI         string
+
+	soft bool
+}
+
+func (e *OAuthError) Error() string {
+	msg := e.Code
+	if e.Description != "" {
+		msg += ": " + e.Description
+	}
+	if e.URI != "" {
+		msg += " (" + e.URI + ")"
+	}
+	return msg
 }
 
+func (e *OAuthError) Is(target error) bool {
+	if target == ErrSoftError {
+		return e.soft
+	}
+	err, ok := errorsByName[e.Code]
+	return ok && err == target
+}
+
+var (
+	// ErrNotStarted is returned by Wait when no flow has been started.
+	ErrNotStarted = errors.New("Dialog not started")
+	// ErrCancelled is returned when the dialog is cancelled.
+	ErrCancelled = errors.New("Dialog cancelled")
+	// ErrTimeout is returned when the timeout set with WithTimeout expires.
+	ErrTimeout = errors.New("Dialog timed out")
+	// ErrNoInteraction is returned when the user doesn't complete the
+	// authorization page within the time set with WithInteractionTimeout.
+	ErrNoInteraction = errors.New("No interaction with the authorization page")
+	// ErrEmptyCallback is returned when the provider redirects with a valid
+	// state but neither a code, a token nor an error.
+	ErrEmptyCallback = errors.New("Callback without code nor error")
+	// ErrStateMismatch is returned when the provider redirects with a missing
+	// state or one which isn't the state of the flow, e.g. in a CSRF attack.
+	ErrStateMismatch = errors.New("Invalid state supplied to RedirectURL")
+	// ErrNilConfig is returned when the dialog was created with a nil
+	// config.
+	ErrNilConfig = errors.New("Dialog has no config")
+)
+
 type handlerResponse struct {
-	State   string
-	Code    string
-	Error   string
-	IdToken string
+	State            string
+	Code             string
+	Scope            string
+	Error            string
+	ErrorDescription string
+	ErrorURI         string
+	IdToken          string
+	AccessToken      string
+	TokenType        string
+	ExpiresIn        string
+	SessionState     string
+	Issuer           string
+	Extra            map[string]string
+	Mode             DeliveryMode
+
+	// Error returned by Dialog.Approve
+	rejected error
+}
+
+// Check whether the response has nothing actionable.
+func (res *handlerResponse) empty() bool {
+	return res.Code == "" && res.Error == "" && res.IdToken == "" && res.AccessToken == ""
+}
+
+// Get what a successful response contains.
+func (res *handlerResponse) content() ResponseContent {
+	hasToken := res.IdToken != "" || res.AccessToken != ""
+	switch {
+	case res.Code != "" && hasToken:
+		return ContentCodeAndToken
+	case hasToken:
+		return ContentToken
+	default:
+		return ContentCode
+	}
+}
+
+// How the provider's response was delivered to the callback, named after the
+// matching response_mode values.
+type DeliveryMode string
+
+const (
+	// Parameters in the query of a GET request.
+	DeliveryQuery DeliveryMode = "query"
+	// Parameters in the body of a POST request.
+	DeliveryFormPost DeliveryMode = "form_post"
+	// Parameters in the fragment of the URL.
+	DeliveryFragment DeliveryMode = "fragment"
+)
+
+// What a successful response from the provider contains.
+type ResponseContent int
+
+const (
+	// Only a code.
+	ContentCode ResponseContent = iota
+	// Only an ID token or an access token, as in the implicit flow.
+	ContentToken
+	// Both a code and a token, as in the OpenID Connect hybrid flow.
+	ContentCodeAndToken
+)
+
+// The result of a successful authorization.
+type Result struct {
+	Code        string
+	IdToken     string
+	AccessToken string
+	TokenType   string
+	State       string
+	// When the access token expires, if the provider said so in a format
+	// which could be parsed.
+	Expiry time.Time
+	// The granted scopes, if the provider returned them.
+	Scope string
+	// The OpenID Connect session state, if the provider supports session
+	// management.
+	SessionState string
+	// The issuer of the response, if the provider sent it as defined in RFC
+	// 9207.
+	Issuer string
+	// Parameters requested with WithCallbackParams, if present.
+	Extra map[string]string
+	// How the response was delivered.
+	Mode DeliveryMode
+	// The redirect URI sent to the provider.
+	RedirectURL string
+	// The claims of the state, if WithSignedState is used.
+	StateClaims map[string]interface{}
+
+	// When the dialog is cancelled or times out, a partial result is
+	// returned along with the error, telling whether the authorization URL
+	// was opened and whether a request reached the callback URL.
+	Opened   bool
+	Received bool
+}
+
+func (res *handlerResponse) result() *Result {
+	return &Result{
+		Code:         res.Code,
+		IdToken:      res.IdToken,
+		AccessToken:  res.AccessToken,
+		TokenType:    res.TokenType,
+		Expiry:       expiry(res.ExpiresIn),
+		State:        res.State,
+		Scope:        res.Scope,
+		SessionState: res.SessionState,
+		Issuer:       res.Issuer,
+		Extra:        res.Extra,
+		Mode:         res.Mode,
+	}
+}
+
+func (res *handlerResponse) oauthError() *OAuthError {
+	return &OAuthError{
+		Code:        res.Error,
+		Description: res.ErrorDescription,
+		URI:         res.ErrorURI,
+	}
 }
 
-func defaultSuccessHandler(w http.ResponseWriter, req *http.Request) {
-	w.Header().Set("Content-Type", "text/html")
-	w.Write([]byte("You can close this window."))
+type resultContextKey struct{}
+
+// Get the result of the authorization from the context of requests passed to
+// SuccessHandler and ErrorHandler.
+func ResultFromContext(ctx context.Context) *Result {
+	res, _ := ctx.Value(resultContextKey{}).(*Result)
+	return res
+}
+
+// The state of an authorization in progress.
+type flow struct {
+	state     string
+	done      chan *handlerResponse
+	results   chan *Result
+	finished  sync.Once
+	completed bool
+	opened    bool
+	received  bool
+
+	// Snapshot of the dialog configuration read by the HTTP handler, so that
+	// it never races with the caller
+	started         time.Time
+	redirectURL     string
+	stateKey        []byte
+	responseType    responseType
+	fragment        bool
+	secret          string
+	secretIssued    bool
+	previous        map[string]superseded
+	callbackPath    string
+	successHandler  http.HandlerFunc
+	successHandlers map[ResponseContent]http.HandlerFunc
+	errorHandler    http.HandlerFunc
+	errorHandlers   map[string]http.HandlerFunc
+	approve         func(*Result) error
+	softErrors      map[string]bool
+	pkceMethod      string
+	nonce           bool
+	issuer          string
+	issuerRequired  bool
 }
 
-// An OAuth2 dialog.
+// A state abandoned for a new flow, still accepted for a while.
+type superseded struct {
+	expiry   time.Time
+	verifier string
+}
+
+// An OAuth2 dialog. Its fields must be set before it is opened, changes made
+// during a flow only apply to the next one.
 type Dialog struct {
 	// If a value is sent to this channel, the dialog is cancelled.
 	Cancel chan bool
 	// HTTP handler called when user after user authorization.
 	SuccessHandler http.HandlerFunc
+	// HTTP handlers called after user authorization, by what the provider
+	// returned. SuccessHandler is called if none matches.
+	SuccessHandlers map[ResponseContent]http.HandlerFunc
+	// HTTP handler called when the provider returns an error.
+	ErrorHandler http.HandlerFunc
+	// HTTP handlers called when the provider returns an error, by error code,
+	// e.g. "access_denied". ErrorHandler is called for other errors.
+	ErrorHandlers map[string]http.HandlerFunc
+	// If set, called with the result of the flow once the provider's
+	// response is received, before the success page is served. If it returns
+	// an error, the error page is served instead and Open returns the error.
+	Approve func(*Result) error
+
+	config      *oauth2.Config
+	scopes      []string
+	listener    net.Listener
+	iface       string
+	stateLength int
+	stateKey    []byte
+	stateClaims map[string]interface{}
+	stateMaxAge time.Duration
+	stateGrace  time.Duration
+	superseded  map[string]superseded
+	keepAlive   bool
+	timeout     time.Duration
+	// Limits of the interaction and code exchange phases
+	interactionTimeout time.Duration
+	exchangeTimeout    time.Duration
+	handlerTimeout     time.Duration
+	authOpts           []oauth2.AuthCodeOption
+
+	endpointParams url.Values
+	clientKey      *clientKey
+	userAgent      string
 
-	config *oauth2.Config
-	done   chan *handlerResponse
+	shutdownGrace       time.Duration
+	rebindAttempts      int
+	requireRefreshToken bool
+	requiredScopes      []string
+	resources           []string
+	responseType        []string
+	fragment            bool
+	flowSecret          bool
+	pkce                bool
+	verifier            string
+	stateStore          StateStore
+	flowID              string
+	callbackParams      []string
+	deviceAuthURL       string
+	opener              Opener
+	fallbackOpeners     []Opener
+	browserEnv          []string
+	urlTransform        func(*url.URL) (*url.URL, error)
+	middleware          []func(http.Handler) http.Handler
+	logger              *log.Logger
+	serverErrorLog      *log.Logger
+	requireHTTPS        bool
+	parURL              string
+	preflightTimeout    time.Duration
+	revocationURL       string
+	jsonStatus          int
+	forceLogin          bool
+	silent              bool
+	interactive         bool
+	diagnostics         bool
+	gzip                bool
+	anyHost             bool
+	strictPath          bool
+	softErrors          map[string]bool
+	issuer              string
+	issuerRequired      bool
+	insecureHosts       []string
+	waitingHandler      http.HandlerFunc
+	successMessage      string
+	errorMessage        string
+	page                page
+	deviceDisplay       DeviceDisplayFunc
+
+	useTLS        bool
+	tlsMinVersion uint16
+	tlsConfig     *tls.Config
+
+	server           *http.Server
+	serverListener   net.Listener
+	redirectOverride string
+	noServer         bool
+	redirectURL      string
+	host             string
+	results          chan *Result
+	logout           chan struct{}
+
+	mu   sync.Mutex
+	flow *flow
 }
 
 // Open the dialog.
 func (d *Dialog) Open(opts ...oauth2.AuthCodeOption) (code, idToken string, err error) {
-	// Start local HTTP server
-	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	res, err := d.OpenContext(context.Background(), opts...)
 	if err != nil {
 		return
 	}
+	return res.Code, res.IdToken, nil
+}
 
-	d.done = make(chan *handlerResponse)
-	defer close(d.done)
-
-	s := &http.Server{Handler: http.HandlerFunc(d.serveHTTP)}
-	go s.Serve(ln)
-	defer ln.Close()
+// Open the dialog and wait for the result until ctx is done. If the dialog is
+// cancelled or times out, a partial result is returned along with the error.
+func (d *Dialog) OpenContext(ctx context.Context, opts ...oauth2.AuthCodeOption) (res *Result, err error) {
+	res, err = d.openRebind(ctx, opts...)
+	if d.silent && interactionRequired(err) {
+		d.logf("oauthdialog: silent authorization failed, retrying interactively: %v", err)
+		d.interactive = true
+		defer func() { d.interactive = false }()
+		res, err = d.openRebind(ctx, opts...)
+	}
+	return
+}
 
-	conf := d.config
-	conf.RedirectURL = "http://" + ln.Addr().String()
+// Open the dialog, restarting the local server if it stops and WithRebind
+// allows it.
+func (d *Dialog) openRebind(ctx context.Context, opts ...oauth2.AuthCodeOption) (res *Result, err error) {
+	for attempt := 0; ; attempt++ {
+		res, err = d.open(ctx, opts...)
+		if attempt >= d.rebindAttempts || !errors.Is(err, ErrServerClosed) || err == ErrServerClosed {
+			return
+		}
+		if d.listener != nil || d.redirectOverride != "" {
+			// The redirect URI can't change
+			return nil, fmt.Errorf("%w, not restarted on a fixed address", err)
+		}
+		d.logf("oauthdialog: restarting local server: %v", err)
+	}
+}
 
-	state, err := generateState()
+// Open the dialog once, on a new local server.
+func (d *Dialog) open(ctx context.Context, opts ...oauth2.AuthCodeOption) (res *Result, err error) {
+	authURL, served, err := d.prepare(ctx, opts...)
 	if err != nil {
 		return
 	}
+	defer func() {
+		if err != nil {
+			// Nothing is left to exchange
+			d.clearSecrets()
+		}
+		if !d.keepAlive || err != nil {
+			d.Close()
+		}
+	}()
+
+	// The opener may block, keep waiting for the callback meanwhile
+	openCtx, cancelOpen := context.WithCancel(ctx)
+	defer cancelOpen()
+	opened := make(chan error, 1)
+	go func() {
+		opened <- d.opener(openCtx, authURL)
+	}()
+
+	return d.wait(ctx, opened, served, nil)
+}
+
+// Start the local server and a new flow, and get the URL to open.
+func (d *Dialog) prepare(ctx context.Context, opts ...oauth2.AuthCodeOption) (authURL string, served <-chan error, err error) {
+	if d.config == nil {
+		return "", nil, ErrNilConfig
+	}
+	if d.preflightTimeout > 0 {
+		if err = d.preflight(ctx); err != nil {
+			return
+		}
+	}
+	if !d.noServer {
+		if served, err = d.startServer(); err != nil {
+			return
+		}
+		defer func() {
+			if err != nil {
+				d.Close()
+			}
+		}()
+	}
 
-	url := conf.AuthCodeURL(state, opts...)
-	if err = open.Run(url); err != nil {
+	authURL, err = d.AuthCodeURL(opts...)
+	if err != nil {
 		return
 	}
+	if d.parURL != "" {
+		if authURL, err = d.pushAuthRequest(ctx, authURL); err != nil {
+			d.endFlow()
+			return
+		}
+	}
+	if d.urlTransform != nil {
+		if authURL, err = d.transformURL(authURL); err != nil {
+			d.endFlow()
+			return
+		}
+	}
+	if d.requireHTTPS {
+		if err = d.checkHTTPS(authURL); err != nil {
+			d.endFlow()
+			return
+		}
+	}
+	return
+}
+
+// Start the local server receiving the provider's redirect.
+func (d *Dialog) startServer() (<-chan error, error) {
+	tlsConfig, err := d.serverTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	// A server kept running by a previous Open would keep serving with the
+	// new flow
+	d.Close()
+
+	// Start local HTTP server
+	ln, err := d.listen()
+	if err != nil {
+		return nil, err
+	}
+	scheme := "http"
+	if tlsConfig != nil {
+		ln = tls.NewListener(ln, tlsConfig)
+		scheme = "https"
+	}
+
+	redirectURL := d.config.RedirectURL
+	if d.redirectOverride != "" {
+		redirectURL = d.redirectOverride
+	} else if ln.Addr().Network() != "unix" {
+		redirectURL = scheme + "://" + ln.Addr().String()
+	}
+
+	// Only honor requests addressed to the advertised loopback redirect URI
+	var host string
+	if u, err := url.Parse(redirectURL); err == nil && !d.anyHost && isLoopback(u.Hostname()) {
+		host = u.Host
+	}
+
+	d.logout = make(chan struct{}, 1)
+	// Errors of the local server, such as TLS handshake errors, are almost
+	// never actionable
+	errorLog := d.serverErrorLog
+	if errorLog == nil {
+		errorLog = log.New(io.Discard, "", 0)
+	}
+	server := &http.Server{Handler: d.CallbackHandler(), ErrorLog: errorLog}
+	d.mu.Lock()
+	d.server = server
+	d.serverListener = ln
+	d.redirectURL = redirectURL
+	d.host = host
+	d.mu.Unlock()
+	track(d, ln.Addr().String())
+	return serve(server, ln), nil
+}
+
+func (d *Dialog) transformURL(authURL string) (string, error) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		return "", err
+	}
+	if u, err = d.urlTransform(u); err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+// Get the HTTP handler receiving the provider's redirect, to use the dialog
+// with an existing server instead of the one started by Open. Mount it at the
+// config's RedirectURL, then start a flow with AuthCodeURL and call Wait.
+// Requests to any other path get a 404, even with a code and a state.
+func (d *Dialog) CallbackHandler() http.Handler {
+	var h http.Handler = http.HandlerFunc(d.serveHTTP)
+	for i := len(d.middleware) - 1; i >= 0; i-- {
+		h = d.middleware[i](h)
+	}
+	return h
+}
+
+// Start a new flow and get the URL of the authorization page. Any flow in
+// progress is abandoned.
+func (d *Dialog) AuthCodeURL(opts ...oauth2.AuthCodeOption) (string, error) {
+	if d.config == nil {
+		return "", ErrNilConfig
+	}
+	var rt responseType
+	var rtOpts []oauth2.AuthCodeOption
+	if d.responseType != nil {
+		var err error
+		if rt, err = parseResponseType(d.responseType); err != nil {
+			return "", err
+		}
+		rtOpts = append(rtOpts, oauth2.SetAuthURLParam("response_type", rt.String()))
+		if rt.fragment() && !d.fragment {
+			rtOpts = append(rtOpts, oauth2.SetAuthURLParam("response_mode", "form_post"))
+		}
+	}
+
+	var state string
+	var err error
+	if d.stateKey != nil {
+		state, err = signState(d.stateKey, d.stateClaims, d.stateMaxAge, d.stateLength)
+	} else {
+		state, err = generateState(d.stateLength)
+	}
+	if err != nil {
+		return "", err
+	}
+	if state == "" {
+		return "", ErrEmptyState
+	}
+	var secret string
+	if d.flowSecret && d.fragment {
+		if secret, err = randomString(stateLength); err != nil {
+			return "", err
+		}
+	}
+
+	d.endFlow()
+	d.mu.Lock()
+	d.flow = &flow{
+		state:           state,
+		done:            make(chan *handlerResponse, 1),
+		started:         time.Now(),
+		results:         make(chan *Result, 1),
+		redirectURL:     d.flowConfig().RedirectURL,
+		stateKey:        d.stateKey,
+		responseType:    rt,
+		fragment:        d.fragment,
+		secret:          secret,
+		callbackPath:    d.callbackPath(),
+		successHandler:  d.SuccessHandler,
+		errorHandler:    d.ErrorHandler,
+		approve:         d.Approve,
+		softErrors:      d.softErrors,
+		issuer:          d.issuer,
+		issuerRequired:  d.issuerRequired,
+		successHandlers: make(map[ResponseContent]http.HandlerFunc, len(d.SuccessHandlers)),
+		errorHandlers:   make(map[string]http.HandlerFunc, len(d.ErrorHandlers)),
+	}
+	for content, h := range d.SuccessHandlers {
+		d.flow.successHandlers[content] = h
+	}
+	for code, h := range d.ErrorHandlers {
+		d.flow.errorHandlers[code] = h
+	}
+	for state, prev := range d.superseded {
+		if time.Now().After(prev.expiry) {
+			delete(d.superseded, state)
+			continue
+		}
+		if d.flow.previous == nil {
+			d.flow.previous = make(map[string]superseded)
+		}
+		d.flow.previous[state] = prev
+	}
+	f := d.flow
+	d.results = f.results
+	d.mu.Unlock()
+
+	opts = append(append(rtOpts, d.authOpts...), opts...)
+	var verifier string
+	if d.pkce {
+		var pkceOpts []oauth2.AuthCodeOption
+		verifier, pkceOpts, err = newPKCE()
+		if err != nil {
+			d.endFlow()
+			return "", err
+		}
+		opts = append(opts, pkceOpts...)
+	}
+	d.mu.Lock()
+	if d.pkce {
+		d.verifier = verifier
+	}
+	d.flowID = state
+	d.mu.Unlock()
+	if err := d.saveFlowState(); err != nil {
+		d.endFlow()
+		return "", err
+	}
+
+	authURL := d.flowConfig().AuthCodeURL(state, opts...)
+	u, err := url.Parse(authURL)
+	if err != nil {
+		d.endFlow()
+		return "", err
+	}
+	q := u.Query()
+	if len(d.resources) > 0 || d.forceLogin || d.silent {
+		// oauth2.SetAuthURLParam can't repeat a parameter
+		for _, r := range d.resources {
+			q.Add("resource", r)
+		}
+		if prompt := d.prompt(q.Get("prompt")); prompt != "" {
+			q.Set("prompt", prompt)
+		}
+		u.RawQuery = q.Encode()
+		authURL = u.String()
+	}
+
+	d.mu.Lock()
+	f.pkceMethod = q.Get("code_challenge_method")
+	f.nonce = q.Get("nonce") != ""
+	d.mu.Unlock()
+	return authURL, nil
+}
+
+// Wait for the result of the flow started by AuthCodeURL. If the dialog is
+// cancelled or times out, a partial result is returned along with the error.
+func (d *Dialog) Wait(ctx context.Context) (*Result, error) {
+	return d.wait(ctx, nil, nil, nil)
+}
+
+// Creates the timers of the dialog phases. Only tests may replace it, to
+// expire them without waiting.
+var newTimer = time.NewTimer
+
+// Get a copy of ctx which is done after timeout, measured with newTimer, and
+// a function reporting whether it expired, as opposed to ctx being done. A
+// zero timeout never expires.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, func() bool, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() bool { return false }, func() {}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	timer := newTimer(timeout)
+	expired := make(chan struct{})
+	go func() {
+		select {
+		case <-timer.C:
+			close(expired)
+			cancel()
+		case <-ctx.Done():
+			timer.Stop()
+		}
+	}()
+	return ctx, func() bool {
+		select {
+		case <-expired:
+			return true
+		default:
+			return false
+		}
+	}, cancel
+}
+
+// Wait for the result of the current flow. If the opener fails, its error is
+// returned. If the local server stops, ErrServerClosed is returned. The
+// interaction timeout is measured with interactionTimer until the opener
+// returns, if it is already running.
+func (d *Dialog) wait(ctx context.Context, opened, served <-chan error, interactionTimer *time.Timer) (*Result, error) {
+	f := d.currentFlow()
+	if f == nil {
+		return nil, ErrNotStarted
+	}
+	defer d.endFlow()
+
+	cancel := d.Cancel
+	var timeout <-chan time.Time
+	if d.timeout > 0 {
+		timer := newTimer(d.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	// The user has interactionTimeout to get through the authorization page
+	// once it is opened
+	var interaction <-chan time.Time
+	defer func() {
+		if interactionTimer != nil {
+			interactionTimer.Stop()
+		}
+	}()
+	startInteraction := func() {
+		if interactionTimer != nil {
+			interactionTimer.Stop()
+		}
+		if d.interactionTimeout > 0 {
+			interactionTimer = newTimer(d.interactionTimeout)
+			interaction = interactionTimer.C
+		}
+	}
+	if interactionTimer != nil {
+		interaction = interactionTimer.C
+	} else if opened == nil {
+		startInteraction()
+	}
+
+	for {
+		select {
+		case err := <-opened:
+			if err != nil {
+				return nil, err
+			}
+			opened = nil
+			d.mu.Lock()
+			f.opened = true
+			d.mu.Unlock()
+			startInteraction()
+		case res := <-f.done:
+			return d.flowResult(f, res)
+		case err := <-served:
+			if errors.Is(err, http.ErrServerClosed) {
+				return nil, ErrServerClosed
+			}
+			return nil, fmt.Errorf("%w: %v", ErrServerClosed, err)
+		case <-cancel:
+			return d.grace(f, ErrCancelled)
+		case <-timeout:
+			return d.grace(f, ErrTimeout)
+		case <-interaction:
+			return d.grace(f, ErrNoInteraction)
+		case <-ctx.Done():
+			return d.grace(f, ctx.Err())
+		}
+	}
+}
 
+// Give a callback already being delivered when the dialog is stopped a chance
+// to complete the flow, otherwise return err along with a partial result.
+func (d *Dialog) grace(f *flow, err error) (*Result, error) {
 	select {
-	case res := <-d.done:
-		if res.State != state {
-			err = errors.New("Invalid state supplied to RedirectURL")
-			return
+	case res := <-f.done:
+		return d.flowResult(f, res)
+	default:
+	}
+
+	d.mu.Lock()
+	inFlight := f.completed
+	d.mu.Unlock()
+	if !inFlight || d.shutdownGrace <= 0 {
+		return d.partialResult(f), err
+	}
+
+	timer := newTimer(d.shutdownGrace)
+	defer timer.Stop()
+	select {
+	case res := <-f.done:
+		return d.flowResult(f, res)
+	case <-timer.C:
+		return d.partialResult(f), err
+	}
+}
+
+// Get what is known of a flow which didn't complete.
+func (d *Dialog) partialResult(f *flow) *Result {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return &Result{
+		RedirectURL: f.redirectURL,
+		Opened:      f.opened,
+		Received:    f.received,
+	}
+}
+
+// Check the provider's response and get the result of the flow.
+func (f *flow) result(res *handlerResponse) (*Result, error) {
+	// An empty state must never match, even an empty one
+	if f.state == "" {
+		return nil, ErrEmptyState
+	}
+	if !f.matches(res.State) {
+		return nil, ErrStateMismatch
+	}
+	// Even an error may come from another provider
+	if err := f.checkIssuer(res); err != nil {
+		return nil, err
+	}
+
+	if res.Error != "" {
+		err := res.oauthError()
+		err.soft = f.softErrors[res.Error]
+		return nil, err
+	}
+	if missing := f.responseType.missing(res); len(missing) > 0 {
+		return nil, fmt.Errorf("%w: %v", ErrIncompleteCallback, strings.Join(missing, ", "))
+	}
+	if res.empty() && !f.responseType["none"] {
+		return nil, ErrEmptyCallback
+	}
+
+	if res.rejected != nil {
+		return nil, res.rejected
+	}
+
+	r := res.result()
+	r.RedirectURL = f.redirectURL
+	if f.stateKey != nil {
+		claims, err := ParseSignedState(f.stateKey, res.State)
+		if err != nil {
+			return nil, err
 		}
+		r.StateClaims = claims
+	}
+	return r, nil
+}
+
+// Check whether the provider's response fails the flow.
+func (f *flow) failed(res *handlerResponse) bool {
+	if res.Error != "" || res.rejected != nil || len(f.responseType.missing(res)) > 0 || f.checkIssuer(res) != nil {
+		return true
+	}
+	return res.empty() && !f.responseType["none"]
+}
+
+// Check whether state is the one of this flow, or one it superseded less
+// than the window set with WithStateGrace ago.
+func (f *flow) matches(state string) bool {
+	if f == nil || f.state == "" || state == "" {
+		return false
+	}
+	if state == f.state {
+		return true
+	}
+	prev, ok := f.previous[state]
+	return ok && time.Now().Before(prev.expiry)
+}
+
+// Get the result of f and, if the response is for a superseded flow,
+// restore its PKCE verifier for Exchange.
+func (d *Dialog) flowResult(f *flow, res *handlerResponse) (*Result, error) {
+	r, err := f.result(res)
+	if err != nil {
+		return nil, err
+	}
+	if prev, ok := f.previous[res.State]; ok {
+		d.mu.Lock()
+		d.verifier = prev.verifier
+		d.mu.Unlock()
+	}
+	return r, nil
+}
 
-		if res.Error != "" {
-			var ok bool
-			if err, ok = errorsByName[res.Error]; ok {
-				return
+func (d *Dialog) currentFlow() *flow {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.flow
+}
+
+// Mark f as completed. False is returned if f is no longer in progress or if
+// it has already been completed.
+func (d *Dialog) completeFlow(f *flow) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if f == nil || d.flow != f || f.completed {
+		return false
+	}
+	f.completed = true
+	return true
+}
+
+// End the flow in progress, if any. Late callbacks are turned away.
+func (d *Dialog) endFlow() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.flow != nil {
+		d.flow.finish(nil)
+		if d.stateGrace > 0 && !d.flow.completed {
+			if d.superseded == nil {
+				d.superseded = make(map[string]superseded)
 			}
+			d.superseded[d.flow.state] = superseded{
+				expiry:   time.Now().Add(d.stateGrace),
+				verifier: d.verifier,
+			}
+		}
+	}
+	d.flow = nil
+}
 
-			err = errors.New(res.Error)
-			return
+// ErrNotBound is returned by ExpectedRedirectURI before the local server is
+// bound, when its port isn't known yet.
+var ErrNotBound = errors.New("Local server not bound")
+
+// Get the redirect URI sent to the provider by the last flow, e.g. to add it
+// to the redirect URIs allowed by the provider. Before the local server is
+// bound, it is the config's RedirectURL; see ExpectedRedirectURI to tell.
+func (d *Dialog) RedirectURL() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.flowConfig().RedirectURL
+}
+
+// Get the exact redirect_uri the dialog sends, scheme, host, port and path
+// included, to check it against the redirect URIs registered with the
+// provider, which OAuth 2.1 requires to match exactly. Unlike RedirectURL,
+// ErrNotBound is returned while the URI isn't final: until Open or Prepare
+// bound the local server, unless it is set with WithRedirectURL, or a flow
+// was started with AuthCodeURL for CallbackHandler or WithWebView, which send
+// the config's RedirectURL.
+func (d *Dialog) ExpectedRedirectURI() (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch {
+	case d.redirectURL != "":
+		return d.redirectURL, nil
+	case d.redirectOverride != "":
+		return d.redirectOverride, nil
+	case d.noServer || (d.flow != nil && d.server == nil):
+		return d.flowConfig().RedirectURL, nil
+	}
+	return "", ErrNotBound
+}
+
+// Clear what is left of the previous flow, such as the PKCE verifier and the
+// redirect URL, before reusing the dialog. Open and AuthCodeURL already start
+// each flow with a new state and abandon the flow in progress, but Exchange
+// would otherwise still use the previous verifier.
+func (d *Dialog) Reset() {
+	d.endFlow()
+	d.mu.Lock()
+	d.verifier = ""
+	d.redirectURL = ""
+	d.superseded = nil
+	d.mu.Unlock()
+	d.deleteFlowState()
+}
+
+// Forget the secrets of the current flow once they can't be used anymore,
+// so that they don't linger in memory. A copy saved to the StateStore is
+// kept, it is deleted by Exchange.
+func (d *Dialog) clearSecrets() {
+	d.mu.Lock()
+	d.verifier = ""
+	d.mu.Unlock()
+}
+
+// Get the config used for the current flow.
+func (d *Dialog) flowConfig() *oauth2.Config {
+	// Work on a copy, the config may be shared with other callers
+	var conf oauth2.Config
+	if d.config != nil {
+		conf = *d.config
+	}
+	conf.Scopes = mergeScopes(conf.Scopes, d.scopes)
+	if d.redirectURL != "" {
+		conf.RedirectURL = d.redirectURL
+	}
+	return &conf
+}
+
+// Get the path of the redirect URL.
+func (d *Dialog) callbackPath() string {
+	redirectURL := d.redirectURL
+	if redirectURL == "" && d.config != nil {
+		redirectURL = d.config.RedirectURL
+	}
+	u, err := url.Parse(redirectURL)
+	if err != nil || u.Path == "" {
+		return "/"
+	}
+	return u.Path
+}
+
+// Check whether a request path is the callback path, ignoring a trailing
+// slash unless WithStrictCallbackPath is used. Only a single trailing slash
+// is ignored and never the root path's, so no other path can match.
+func (d *Dialog) matchPath(path, callbackPath string) bool {
+	if path == callbackPath {
+		return true
+	}
+	if d.strictPath {
+		return false
+	}
+	return trimSlash(path) == trimSlash(callbackPath)
+}
+
+func trimSlash(path string) string {
+	if len(path) > 1 && strings.HasSuffix(path, "/") {
+		return path[:len(path)-1]
+	}
+	return path
+}
+
+// Parse the parameters of the provider's response, capturing the extra ones.
+func parseParams(params url.Values, extra []string) *handlerResponse {
+	res := &handlerResponse{
+		State:            params.Get("state"),
+		Code:             params.Get("code"),
+		Scope:            params.Get("scope"),
+		Error:            params.Get("error"),
+		ErrorDescription: params.Get("error_description"),
+		ErrorURI:         params.Get("error_uri"),
+		IdToken:          params.Get("id_token"),
+		AccessToken:      params.Get("access_token"),
+		TokenType:        params.Get("token_type"),
+		ExpiresIn:        params.Get("expires_in"),
+		SessionState:     params.Get("session_state"),
+		Issuer:           params.Get("iss"),
+		Mode:             DeliveryQuery,
+	}
+	for _, name := range extra {
+		if v, ok := params[name]; ok && len(v) > 0 {
+			if res.Extra == nil {
+				res.Extra = make(map[string]string)
+			}
+			res.Extra[name] = v[0]
 		}
+	}
+	return res
+}
 
-		code = res.Code
-		idToken = res.IdToken
-		return
-	case <-d.Cancel:
-		return
+// Deliver the provider's response to f. Only the first response of a flow in
+// progress is delivered, false is returned for any other.
+func (d *Dialog) deliver(f *flow, res *handlerResponse) bool {
+	if !d.completeFlow(f) {
+		return false
+	}
+
+	r, err := f.result(res)
+	if err == nil && f.approve != nil {
+		if res.rejected = f.approve(r); res.rejected != nil {
+			err = res.rejected
+		}
 	}
+	if err != nil {
+		r = nil
+	}
+	f.finish(r)
+
+	// completeFlow lets a single callback through and done has room for it,
+	// so this never blocks the request goroutine
+	f.done <- res
+	return true
+}
+
+// Send r, unless nil, to the channel returned by Done and close it.
+func (f *flow) finish(r *Result) {
+	f.finished.Do(func() {
+		if r != nil {
+			f.results <- r
+		}
+		close(f.results)
+	})
+}
+
+// Get a channel receiving the result of the last flow started once it
+// completes successfully, then closed. It is closed without a result if the
+// flow fails or is abandoned. Done returns nil until a flow is started with
+// Open or AuthCodeURL, and a new channel for each flow.
+func (d *Dialog) Done() <-chan *Result {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.results
 }
 
 func (d *Dialog) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	d.mu.Lock()
+	host := d.host
+	ownServer := d.server != nil
+	f := d.flow
+	path := d.callbackPath()
+	if f != nil {
+		path = f.callbackPath
+	}
+	d.mu.Unlock()
+	if host != "" && !strings.EqualFold(req.Host, host) {
+		http.Error(w, "Invalid Host header", http.StatusBadRequest)
+		return
+	}
+
+	// When mounted on the caller's mux, leave its other routes alone
+	if req.URL.Path == logoutPath && ownServer {
+		d.serveLogout(w, req)
+		return
+	}
+	if !d.matchPath(req.URL.Path, path) {
+		http.NotFound(w, req)
+		return
+	}
+
 	err := req.ParseForm()
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	state := req.Form.Get("state")
-	code := req.Form.Get("code")
-	formError := req.Form.Get("error")
-	idToken := req.Form.Get("id_token")
+	if f != nil {
+		d.mu.Lock()
+		f.received = true
+		d.mu.Unlock()
+	}
 
-	res := &handlerResponse{
-		State:   state,
-		Code:    code,
-		Error:   formError,
-		IdToken: idToken,
+	bridged := f != nil && f.fragment && isFragmentBridge(req)
+	res := parseParams(req.Form, d.callbackParams)
+	if bridged {
+		res.Mode = DeliveryFragment
+	} else if req.Method == http.MethodPost && req.PostForm.Get("state") != "" {
+		res.Mode = DeliveryFormPost
 	}
 
-	if res.State == "" || (res.Code == "" && res.Error == "") {
-		w.WriteHeader(http.StatusNotFound)
+	if res.ExpiresIn != "" {
+		if _, ok := parseExpiresIn(res.ExpiresIn); !ok {
+			d.logf("oauthdialog: ignoring malformed expires_in %q", res.ExpiresIn)
+		}
+	}
+
+	empty := res.empty()
+	if f != nil && f.fragment && req.Method == http.MethodGet {
+		// The response may be in the fragment, whatever the query holds: let
+		// the bridge post both back, only then is it delivered
+		serveFragmentBridge(w, d.bridgeMarker(f))
+		return
+	}
+	// With a flow secret, the bridge is the only way in: a POST without the
+	// secret, with or without a marker, is rejected
+	if f != nil && f.fragment && req.Method == http.MethodPost && !f.validMarker(req.PostForm.Get(fragmentMarker)) {
+		http.Error(w, "Invalid flow secret", http.StatusForbidden)
+		return
+	}
+	if empty && f.matches(res.State) {
+		// Nothing actionable but the state is valid: fail the flow rather than
+		// leaving it hanging
+		d.logf("oauthdialog: callback without code nor error: %v", redactParams(req.Form))
+	} else if res.State == "" || empty {
+		if d.waitingHandler != nil && f != nil {
+			d.waitingHandler(w, req)
+			return
+		}
+		http.NotFound(w, req)
 		return
 	}
 
-	d.done <- res
+	if !d.deliver(f, res) {
+		completedHandler(w, req)
+		return
+	}
 
-	if d.SuccessHandler != nil {
-		d.SuccessHandler(w, req)
+	h := f.successHandler
+	if contentHandler, ok := f.successHandlers[res.content()]; ok {
+		h = contentHandler
 	}
+	fallback, errorFallback := d.defaultHandlers()
+	if f.failed(res) {
+		fallback = errorFallback
+		h = f.errorHandler
+		if codeHandler, ok := f.errorHandlers[res.Error]; ok && res.Error != "" {
+			h = codeHandler
+		}
+	}
+	if h != nil {
+		ctx := context.WithValue(req.Context(), resultContextKey{}, res.result())
+		if d.diagnostics {
+			ctx = context.WithValue(ctx, diagnosticsContextKey{}, f.diagnostics(req, res))
+		}
+		if d.gzip {
+			h = gzipHandler(h)
+			fallback = gzipHandler(fallback)
+		}
+		d.serveBounded(w, req.WithContext(ctx), h, fallback)
+	}
+}
+
+// Create a new OAuth2 dialog. The dialog never modifies conf, but reads it
+// on each flow, so changes made by the caller meanwhile apply; NewFromConfig
+// is safer when conf is shared.
+func New(conf *oauth2.Config, opts ...Option) *Dialog {
+	d := &Dialog{
+		Cancel:          make(chan bool),
+		config:          conf,
+		opener:          defaultOpener,
+		successMessage:  defaultSuccessMessage,
+		errorMessage:    defaultErrorMessage,
+		shutdownGrace:   defaultShutdownGrace,
+		exchangeTimeout: defaultExchangeTimeout,
+		handlerTimeout:  defaultHandlerTimeout,
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	if len(d.fallbackOpeners) > 0 {
+		d.opener = FallbackOpener(append([]Opener{d.opener}, d.fallbackOpeners...)...)
+	}
+	d.SuccessHandler, d.ErrorHandler = d.defaultHandlers()
+	return d
 }
 
-// Create a new OAuth2 dialog.
-func New(conf *oauth2.Config) *Dialog {
-	return &Dialog{
-		Cancel:         make(chan bool),
-		SuccessHandler: defaultSuccessHandler,
-		config:         conf,
+// Get the success and error handlers set by New, according to the options.
+func (d *Dialog) defaultHandlers() (success, failure http.HandlerFunc) {
+	if d.jsonStatus != 0 {
+		return JSONHandler(d.jsonStatus, map[string]string{"status": "ok"}),
+			JSONHandler(d.jsonStatus, map[string]string{"status": "error"})
 	}
+	return d.page.handler(d.successMessage), d.page.handler(d.errorMessage)
+}
+
+// Create a new OAuth2 dialog with its own copy of conf, which the caller can
+// then modify or share freely.
+func NewFromConfig(conf oauth2.Config, opts ...Option) *Dialog {
+	conf.Scopes = append([]string(nil), conf.Scopes...)
+	return New(&conf, opts...)
 }
 
 // Create a new OAuth2 dialog and open it.
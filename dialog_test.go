@@ -0,0 +1,766 @@
+package oauthdialog
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// Get a config for a provider at an unreachable address, with a loopback
+// redirect URI on any port.
+func testConfig() *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:    "id",
+		RedirectURL: "http://127.0.0.1",
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  "https://provider.example/auth",
+			TokenURL: "https://provider.example/token",
+		},
+	}
+}
+
+// Get an opener acting as a provider which immediately redirects back with
+// params and the state of the authorization URL.
+func redirectOpener(params url.Values) Opener {
+	return func(ctx context.Context, authURL string) error {
+		u, err := url.Parse(authURL)
+		if err != nil {
+			return err
+		}
+		q := u.Query()
+		values := url.Values{"state": {q.Get("state")}}
+		for name, v := range params {
+			values[name] = v
+		}
+		go func() {
+			resp, err := http.Get(q.Get("redirect_uri") + "?" + values.Encode())
+			if err == nil {
+				resp.Body.Close()
+			}
+		}()
+		return nil
+	}
+}
+
+func TestSoftError(t *testing.T) {
+	d := New(testConfig(),
+		WithOpener(redirectOpener(url.Values{"error": {"interaction_required"}})),
+		WithSoftErrors("interaction_required"),
+	)
+	_, err := d.OpenContext(context.Background())
+	if !errors.Is(err, ErrSoftError) {
+		t.Fatalf("got error %v, want ErrSoftError", err)
+	}
+	if !errors.Is(err, ErrInteractionRequired) {
+		t.Errorf("got error %v, want ErrInteractionRequired", err)
+	}
+}
+
+func TestHardError(t *testing.T) {
+	d := New(testConfig(),
+		WithOpener(redirectOpener(url.Values{"error": {"access_denied"}})),
+		WithSoftErrors("interaction_required"),
+	)
+	_, err := d.OpenContext(context.Background())
+	if !errors.Is(err, ErrAccessDenied) {
+		t.Fatalf("got error %v, want ErrAccessDenied", err)
+	}
+	if errors.Is(err, ErrSoftError) {
+		t.Errorf("access_denied matches ErrSoftError")
+	}
+}
+
+// Start a flow on d and get the callback URL a provider would redirect to
+// with params.
+func callbackURL(t *testing.T, d *Dialog, params url.Values) string {
+	authURL, err := d.AuthCodeURL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	values := url.Values{"state": {q.Get("state")}}
+	for name, v := range params {
+		values[name] = v
+	}
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if redirect.Path == "" {
+		redirect.Path = "/"
+	}
+	redirect.RawQuery = values.Encode()
+	return redirect.String()
+}
+
+func TestSlowSuccessHandler(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	d := New(testConfig(), WithHandlerTimeout(50*time.Millisecond))
+	d.SuccessHandler = func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("partial"))
+		<-release
+	}
+
+	rec := httptest.NewRecorder()
+	start := time.Now()
+	d.CallbackHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, callbackURL(t, d, url.Values{"code": {"code"}}), nil))
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("handler served after %v", elapsed)
+	}
+	if body := rec.Body.String(); !strings.Contains(body, defaultSuccessMessage) || strings.Contains(body, "partial") {
+		t.Errorf("got body %q, want the default page", body)
+	}
+
+	res, err := d.Wait(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Code != "code" {
+		t.Errorf("got code %q", res.Code)
+	}
+}
+
+func TestSuccessHandlerInTime(t *testing.T) {
+	d := New(testConfig(), WithHandlerTimeout(time.Minute))
+	d.SuccessHandler = func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("X-Custom", "yes")
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("custom"))
+	}
+
+	rec := httptest.NewRecorder()
+	d.CallbackHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, callbackURL(t, d, url.Values{"code": {"code"}}), nil))
+	if rec.Code != http.StatusAccepted || rec.Header().Get("X-Custom") != "yes" || rec.Body.String() != "custom" {
+		t.Errorf("got response %v %v %q", rec.Code, rec.Header(), rec.Body.String())
+	}
+}
+
+// Make the timers of the given duration expire at once until the test ends.
+func expireTimers(t *testing.T, timeout time.Duration) {
+	t.Cleanup(func() { newTimer = time.NewTimer })
+	newTimer = func(d time.Duration) *time.Timer {
+		if d == timeout {
+			d = 0
+		}
+		return time.NewTimer(d)
+	}
+}
+
+func TestInteractionTimeout(t *testing.T) {
+	expireTimers(t, time.Hour)
+	opened := false
+	d := New(testConfig(),
+		WithInteractionTimeout(time.Hour),
+		WithOpener(func(ctx context.Context, url string) error {
+			opened = true
+			return nil
+		}),
+	)
+	res, err := d.OpenContext(context.Background())
+	if !errors.Is(err, ErrNoInteraction) {
+		t.Fatalf("got error %v, want ErrNoInteraction", err)
+	}
+	if !opened || res == nil || !res.Opened || res.Received {
+		t.Errorf("got partial result %+v", res)
+	}
+}
+
+func TestPreparedInteractionTimeout(t *testing.T) {
+	expireTimers(t, time.Hour)
+	d := New(testConfig(), WithInteractionTimeout(time.Hour))
+	p, err := d.Prepare()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The URL may be shown without Open, e.g. behind a sign in button
+	if _, err := p.Complete(context.Background()); !errors.Is(err, ErrNoInteraction) {
+		t.Fatalf("got error %v, want ErrNoInteraction", err)
+	}
+}
+
+func TestPreparedInteractionTimeoutRestartsOnOpen(t *testing.T) {
+	redirect := redirectOpener(url.Values{"code": {"code"}})
+	d := New(testConfig(),
+		WithInteractionTimeout(200*time.Millisecond),
+		WithOpener(func(ctx context.Context, url string) error {
+			time.Sleep(400 * time.Millisecond)
+			return redirect(ctx, url)
+		}),
+	)
+	p, err := d.Prepare()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Open()
+	if _, err := p.Complete(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInteractionTimeoutStartsOnceOpened(t *testing.T) {
+	redirect := redirectOpener(url.Values{"code": {"code"}})
+	d := New(testConfig(),
+		WithInteractionTimeout(200*time.Millisecond),
+		WithOpener(func(ctx context.Context, url string) error {
+			// A slow opener doesn't eat into the interaction time
+			time.Sleep(400 * time.Millisecond)
+			return redirect(ctx, url)
+		}),
+	)
+	if _, err := d.OpenContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExpectedRedirectURI(t *testing.T) {
+	d := New(testConfig())
+	if _, err := d.ExpectedRedirectURI(); err != ErrNotBound {
+		t.Fatalf("got error %v before binding, want ErrNotBound", err)
+	}
+
+	p, err := d.Prepare()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	expected, err := d.ExpectedRedirectURI()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(p.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent := u.Query().Get("redirect_uri"); sent != expected {
+		t.Errorf("got %q, sent %q", expected, sent)
+	}
+	if expected != d.RedirectURL() {
+		t.Errorf("got %q, RedirectURL returns %q", expected, d.RedirectURL())
+	}
+}
+
+func TestExpectedRedirectURICallbackHandler(t *testing.T) {
+	d := New(testConfig())
+	authURL, err := d.AuthCodeURL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(authURL)
+	expected, err := d.ExpectedRedirectURI()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent := u.Query().Get("redirect_uri"); sent != expected {
+		t.Errorf("got %q, sent %q", expected, sent)
+	}
+}
+
+func TestEmptyState(t *testing.T) {
+	f := &flow{}
+	if _, err := f.result(&handlerResponse{Code: "code"}); err != ErrEmptyState {
+		t.Errorf("got error %v for an empty flow state, want ErrEmptyState", err)
+	}
+	if f.matches("") {
+		t.Error("empty states match")
+	}
+
+	f = &flow{state: "state"}
+	if _, err := f.result(&handlerResponse{Code: "code"}); err != ErrStateMismatch {
+		t.Errorf("got error %v for an empty callback state, want ErrStateMismatch", err)
+	}
+}
+
+func TestCallbackWithoutState(t *testing.T) {
+	d := New(testConfig(), WithTimeout(100*time.Millisecond))
+	cb := callbackURL(t, d, url.Values{"code": {"code"}})
+	u, _ := url.Parse(cb)
+	q := u.Query()
+	q.Del("state")
+	u.RawQuery = q.Encode()
+
+	rec := httptest.NewRecorder()
+	d.CallbackHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, u.String(), nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("got status %v, want 404", rec.Code)
+	}
+	if _, err := d.Wait(context.Background()); err != ErrTimeout {
+		t.Errorf("got error %v, want the flow to still wait", err)
+	}
+}
+
+func TestBlockingOpenerCancelled(t *testing.T) {
+	openerDone := make(chan error, 1)
+	d := New(testConfig(), WithOpener(func(ctx context.Context, url string) error {
+		<-ctx.Done()
+		openerDone <- ctx.Err()
+		return ctx.Err()
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := d.OpenContext(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got error %v, want the context's", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("OpenContext returned after %v", elapsed)
+	}
+	select {
+	case <-openerDone:
+	case <-time.After(2 * time.Second):
+		t.Error("the opener's context isn't done")
+	}
+}
+
+func TestBlockingOpenerCompletes(t *testing.T) {
+	redirect := redirectOpener(url.Values{"code": {"code"}})
+	d := New(testConfig(), WithOpener(func(ctx context.Context, url string) error {
+		// The callback arrives while the opener still blocks
+		if err := redirect(ctx, url); err != nil {
+			return err
+		}
+		<-ctx.Done()
+		return nil
+	}))
+	res, err := d.OpenContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Code != "code" {
+		t.Errorf("got code %q", res.Code)
+	}
+}
+
+func TestDeliveryMode(t *testing.T) {
+	t.Run("query", func(t *testing.T) {
+		d := New(testConfig())
+		rec := httptest.NewRecorder()
+		d.CallbackHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, callbackURL(t, d, url.Values{"code": {"code"}}), nil))
+		res, err := d.Wait(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Mode != DeliveryQuery {
+			t.Errorf("got mode %q, want query", res.Mode)
+		}
+	})
+
+	t.Run("form_post", func(t *testing.T) {
+		d := New(testConfig())
+		cb := callbackURL(t, d, nil)
+		u, _ := url.Parse(cb)
+		postCallback(d, cb, url.Values{"state": {u.Query().Get("state")}, "code": {"code"}})
+		res, err := d.Wait(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Mode != DeliveryFormPost {
+			t.Errorf("got mode %q, want form_post", res.Mode)
+		}
+	})
+
+	t.Run("fragment", func(t *testing.T) {
+		d := New(testConfig(), WithFragmentCapture())
+		cb := callbackURL(t, d, nil)
+		u, _ := url.Parse(cb)
+		marker := loadBridge(t, d, cb)
+		postCallback(d, cb, url.Values{"state": {u.Query().Get("state")}, "code": {"code"}, fragmentMarker: {marker}})
+		res, err := d.Wait(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Mode != DeliveryFragment {
+			t.Errorf("got mode %q, want fragment", res.Mode)
+		}
+	})
+}
+
+func TestCallbackAfterCompletion(t *testing.T) {
+	var callback string
+	d := New(testConfig(), WithKeepAlive(), WithOpener(func(ctx context.Context, authURL string) error {
+		u, _ := url.Parse(authURL)
+		q := u.Query()
+		callback = q.Get("redirect_uri") + "?" + url.Values{"code": {"code"}, "state": {q.Get("state")}}.Encode()
+		go func() {
+			resp, err := http.Get(callback)
+			if err == nil {
+				resp.Body.Close()
+			}
+		}()
+		return nil
+	}))
+	defer d.Close()
+	res, err := d.OpenContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Code != "code" {
+		t.Errorf("got code %q", res.Code)
+	}
+
+	resp, err := http.Get(callback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(body), completedMessage) {
+		t.Errorf("got page %q for the second callback, want the completed page", body)
+	}
+	// Done only ever receives the first result
+	done := d.Done()
+	<-done
+	select {
+	case res, ok := <-done:
+		if ok {
+			t.Errorf("second callback delivered %+v", res)
+		}
+	default:
+	}
+}
+
+// Run with -race: fields set during a flow take effect on the next one only.
+func TestSetFieldDuringFlow(t *testing.T) {
+	var d *Dialog
+	redirect := redirectOpener(url.Values{"code": {"code"}})
+	replaced := func(w http.ResponseWriter, req *http.Request) {
+		t.Error("handler set during the flow served it")
+	}
+	d = New(testConfig(), WithOpener(func(ctx context.Context, url string) error {
+		if err := redirect(ctx, url); err != nil {
+			return err
+		}
+		// The callback is served concurrently
+		d.SuccessHandler = replaced
+		d.ErrorHandler = replaced
+		d.ErrorHandlers = map[string]http.HandlerFunc{"access_denied": replaced}
+		return nil
+	}))
+	res, err := d.OpenContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Code != "code" {
+		t.Errorf("got code %q", res.Code)
+	}
+}
+
+func TestReset(t *testing.T) {
+	srv, form := tokenServer(t, `{"access_token":"access","token_type":"bearer"}`)
+	conf := testConfig()
+	conf.Endpoint.TokenURL = srv.URL
+	d := New(conf, WithPKCE())
+	if _, err := d.AuthCodeURL(); err != nil {
+		t.Fatal(err)
+	}
+	if d.verifier == "" {
+		t.Fatal("no verifier stored")
+	}
+
+	d.Reset()
+	if d.verifier != "" {
+		t.Errorf("got verifier %q after Reset", d.verifier)
+	}
+	if _, err := d.Wait(context.Background()); err != ErrNotStarted {
+		t.Errorf("got error %v waiting after Reset, want ErrNotStarted", err)
+	}
+	if _, err := d.Exchange(context.Background(), "code"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := (*form)["code_verifier"]; ok {
+		t.Errorf("previous verifier sent: %v", *form)
+	}
+}
+
+// The callback is accepted even though nobody is waiting on the flow yet.
+func TestDeliverWithoutWaiter(t *testing.T) {
+	d := New(testConfig())
+	cb := callbackURL(t, d, url.Values{"code": {"code"}})
+
+	served := make(chan int, 1)
+	go func() {
+		rec := httptest.NewRecorder()
+		d.CallbackHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, cb, nil))
+		served <- rec.Code
+	}()
+	select {
+	case code := <-served:
+		if code != http.StatusOK {
+			t.Errorf("got status %v", code)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the handler blocked until Wait")
+	}
+
+	res, err := d.Wait(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Code != "code" {
+		t.Errorf("got code %q", res.Code)
+	}
+}
+
+// Get an opener sending the callback with the given Host header, recording
+// the status, then redirecting as usual.
+func spoofingOpener(host string, status *int) Opener {
+	redirect := redirectOpener(url.Values{"code": {"code"}})
+	return func(ctx context.Context, authURL string) error {
+		u, _ := url.Parse(authURL)
+		q := u.Query()
+		req, err := http.NewRequest(http.MethodGet, q.Get("redirect_uri")+"/?code=spoofed&state="+q.Get("state"), nil)
+		if err != nil {
+			return err
+		}
+		req.Host = host
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			// The server may stop once the callback is delivered
+			return nil
+		}
+		resp.Body.Close()
+		*status = resp.StatusCode
+		if resp.StatusCode != http.StatusBadRequest {
+			return nil
+		}
+		return redirect(ctx, authURL)
+	}
+}
+
+func TestSpoofedHost(t *testing.T) {
+	var status int
+	d := New(testConfig(), WithOpener(spoofingOpener("evil.example", &status)))
+	res, err := d.OpenContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != http.StatusBadRequest {
+		t.Errorf("got status %v for a spoofed host, want 400", status)
+	}
+	if res.Code != "code" {
+		t.Errorf("got code %q, want the genuine callback's", res.Code)
+	}
+
+	// The flow may complete before the opener gets the response
+	d = New(testConfig(), WithAnyHost(), WithOpener(spoofingOpener("evil.example", new(int))))
+	if res, err = d.OpenContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if res.Code != "spoofed" {
+		t.Errorf("got code %q with WithAnyHost, want the spoofed callback's", res.Code)
+	}
+}
+
+func TestNilConfig(t *testing.T) {
+	d := New(nil)
+	if _, _, err := d.Open(); err != ErrNilConfig {
+		t.Errorf("got error %v from Open, want ErrNilConfig", err)
+	}
+	if _, err := d.AuthCodeURL(); err != ErrNilConfig {
+		t.Errorf("got error %v from AuthCodeURL, want ErrNilConfig", err)
+	}
+	if _, err := d.Token(context.Background()); err != ErrNilConfig {
+		t.Errorf("got error %v from Token, want ErrNilConfig", err)
+	}
+	if _, err := d.Exchange(context.Background(), "code"); err != ErrNilConfig {
+		t.Errorf("got error %v from Exchange, want ErrNilConfig", err)
+	}
+	if err := d.Revoke(context.Background(), &oauth2.Token{AccessToken: "access"}); err != ErrNilConfig {
+		t.Errorf("got error %v from Revoke, want ErrNilConfig", err)
+	}
+	if _, err := d.DeviceFlowContext(context.Background()); err != ErrNilConfig {
+		t.Errorf("got error %v from DeviceFlowContext, want ErrNilConfig", err)
+	}
+}
+
+func TestSessionState(t *testing.T) {
+	d := New(testConfig())
+	rec := httptest.NewRecorder()
+	d.CallbackHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, callbackURL(t, d, url.Values{"code": {"code"}, "session_state": {"query-session"}}), nil))
+	res, err := d.Wait(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.SessionState != "query-session" {
+		t.Errorf("got session state %q from the query", res.SessionState)
+	}
+
+	d = New(testConfig())
+	cb := callbackURL(t, d, nil)
+	u, _ := url.Parse(cb)
+	postCallback(d, cb, url.Values{"state": {u.Query().Get("state")}, "code": {"code"}, "session_state": {"posted-session"}})
+	if res, err = d.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if res.SessionState != "posted-session" {
+		t.Errorf("got session state %q from form_post", res.SessionState)
+	}
+
+	d = New(testConfig(), WithFragmentCapture())
+	cb = callbackURL(t, d, nil)
+	u, _ = url.Parse(cb)
+	marker := loadBridge(t, d, cb)
+	postCallback(d, cb, url.Values{"state": {u.Query().Get("state")}, "code": {"code"}, "session_state": {"fragment-session"}, fragmentMarker: {marker}})
+	if res, err = d.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if res.SessionState != "fragment-session" {
+		t.Errorf("got session state %q from the fragment", res.SessionState)
+	}
+}
+
+func TestNewFromConfig(t *testing.T) {
+	srv, _ := tokenServer(t, `{"access_token":"access","token_type":"bearer","scope":"openid email"}`)
+	conf := *testConfig()
+	conf.Endpoint.TokenURL = srv.URL
+	conf.Scopes = []string{"openid"}
+	want := conf
+	want.Scopes = []string{"openid"}
+
+	d := NewFromConfig(conf, WithScopes("email"), WithPKCE(), WithOpener(redirectOpener(url.Values{"code": {"code"}})))
+	if _, err := d.Token(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(conf, want) {
+		t.Errorf("config changed to %+v", conf)
+	}
+
+	// Nor is the dialog affected by changes to the caller's copy
+	conf.ClientID = "other"
+	conf.Scopes[0] = "changed"
+	q := authQuery(t, d)
+	if q.Get("client_id") != "id" || q.Get("scope") != "openid email" {
+		t.Errorf("got query %v after changing the caller's config", q)
+	}
+}
+
+func TestMountedHandlerIsolation(t *testing.T) {
+	conf := testConfig()
+	conf.RedirectURL = "http://app.example/oauth/callback"
+	d := New(conf)
+	mux := http.NewServeMux()
+	mux.Handle("/oauth/", d.CallbackHandler())
+	mux.HandleFunc("/app", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("app"))
+	})
+
+	cb := callbackURL(t, d, url.Values{"code": {"code"}})
+	u, _ := url.Parse(cb)
+	for _, path := range []string{"/app", "/oauth/other", "/oauth/callback/extra"} {
+		u.Path = path
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, u.String(), nil))
+		if path == "/app" && rec.Body.String() != "app" {
+			t.Errorf("got page %q for the app route", rec.Body.String())
+		} else if path != "/app" && rec.Code != http.StatusNotFound {
+			t.Errorf("got status %v for %v, want 404", rec.Code, path)
+		}
+	}
+	select {
+	case res := <-d.Done():
+		t.Fatalf("delivered %+v from another route", res)
+	default:
+	}
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, cb, nil))
+	res, err := d.Wait(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Code != "code" {
+		t.Errorf("got code %q", res.Code)
+	}
+}
+
+func TestApproveRejects(t *testing.T) {
+	errRejected := errors.New("rejected by the app")
+	var approved *Result
+	d := New(testConfig(), WithOpener(redirectOpener(url.Values{"code": {"code"}})))
+	d.Approve = func(r *Result) error {
+		approved = r
+		return errRejected
+	}
+	// The page is served once the result is delivered
+	served := make(chan bool, 1)
+	d.SuccessHandler = func(w http.ResponseWriter, req *http.Request) {
+		served <- false
+	}
+	d.ErrorHandler = func(w http.ResponseWriter, req *http.Request) {
+		served <- true
+		w.WriteHeader(http.StatusForbidden)
+	}
+
+	res, err := d.OpenContext(context.Background())
+	if err != errRejected {
+		t.Fatalf("got result %+v and error %v, want the Approve error", res, err)
+	}
+	if approved == nil || approved.Code != "code" {
+		t.Errorf("Approve got %+v", approved)
+	}
+	select {
+	case isError := <-served:
+		if !isError {
+			t.Error("success page served")
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("no page served")
+	}
+	if _, ok := <-d.Done(); ok {
+		t.Error("rejected result sent to Done")
+	}
+}
+
+func TestMatchPath(t *testing.T) {
+	d := New(testConfig())
+	strict := New(testConfig(), WithStrictCallbackPath())
+	for _, configured := range []string{"/callback", "/callback/"} {
+		for _, path := range []string{"/callback", "/callback/"} {
+			if !d.matchPath(path, configured) {
+				t.Errorf("%q doesn't match %q", path, configured)
+			}
+			if strict.matchPath(path, configured) != (path == configured) {
+				t.Errorf("%q matches %q with a strict path: %v", path, configured, path != configured)
+			}
+		}
+		for _, path := range []string{"/", "/callback//", "/callbacks", "/callback/x", "/x/callback", "/Callback"} {
+			if d.matchPath(path, configured) {
+				t.Errorf("%q matches %q", path, configured)
+			}
+		}
+	}
+	if !d.matchPath("/", "/") || d.matchPath("/callback", "/") {
+		t.Error("root path mismatch")
+	}
+}
+
+func TestCallbackTrailingSlash(t *testing.T) {
+	for _, configured := range []string{"/callback", "/callback/"} {
+		for _, path := range []string{"/callback", "/callback/"} {
+			conf := testConfig()
+			conf.RedirectURL = "http://127.0.0.1:8080" + configured
+			d := New(conf)
+			cb, _ := url.Parse(callbackURL(t, d, url.Values{"code": {"code"}}))
+			cb.Path = path
+			d.CallbackHandler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, cb.String(), nil))
+			if _, err := d.Wait(context.Background()); err != nil {
+				t.Errorf("got error %v for %q configured as %q", err, path, configured)
+			}
+		}
+	}
+}
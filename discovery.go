@@ -0,0 +1,165 @@
+package oauthdialog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"golang.org/x/oauth2"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const discoveryPath = "/.well-known/openid-configuration"
+
+// The provider metadata of an OpenID Connect discovery document, as defined in
+// OpenID Connect Discovery section 3 and RFC 8414.
+type Discovery struct {
+	Issuer                             string   `json:"issuer"`
+	AuthorizationEndpoint              string   `json:"authorization_endpoint"`
+	TokenEndpoint                      string   `json:"token_endpoint"`
+	RevocationEndpoint                 string   `json:"revocation_endpoint,omitempty"`
+	DeviceAuthorizationEndpoint        string   `json:"device_authorization_endpoint,omitempty"`
+	PushedAuthorizationRequestEndpoint string   `json:"pushed_authorization_request_endpoint,omitempty"`
+	ResponseTypesSupported             []string `json:"response_types_supported,omitempty"`
+	ResponseModesSupported             []string `json:"response_modes_supported,omitempty"`
+	CodeChallengeMethodsSupported      []string `json:"code_challenge_methods_supported,omitempty"`
+	ScopesSupported                    []string `json:"scopes_supported,omitempty"`
+	// Whether the provider sends the iss parameter defined in RFC 9207.
+	AuthorizationResponseIssParameterSupported bool `json:"authorization_response_iss_parameter_supported,omitempty"`
+}
+
+// An error returned when the discovery document of an issuer can't be
+// fetched or is invalid.
+type DiscoveryError struct {
+	Issuer string
+	// The HTTP status code returned for the document, or zero if no
+	// response was received.
+	StatusCode int
+
+	err error
+}
+
+func (e *DiscoveryError) Error() string {
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("Discovery of %v failed with status %v: %v", e.Issuer, e.StatusCode, e.err)
+	}
+	return fmt.Sprintf("Discovery of %v failed: %v", e.Issuer, e.err)
+}
+
+// Unwrap returns the underlying error.
+func (e *DiscoveryError) Unwrap() error {
+	return e.err
+}
+
+// Discovery documents by issuer, fetched once per process.
+var discoveries struct {
+	sync.Mutex
+	docs map[string]*Discovery
+}
+
+// Fetch the discovery document of issuer, with the HTTP client of ctx as
+// defined by the oauth2 package. Documents are cached by issuer, failures
+// aren't. Failures are reported as a *DiscoveryError.
+func Discover(ctx context.Context, issuer string) (*Discovery, error) {
+	issuer = strings.TrimSuffix(issuer, "/")
+	discoveries.Lock()
+	doc, ok := discoveries.docs[issuer]
+	discoveries.Unlock()
+	if ok {
+		return doc, nil
+	}
+
+	doc, err := fetchDiscovery(ctx, issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	discoveries.Lock()
+	if discoveries.docs == nil {
+		discoveries.docs = make(map[string]*Discovery)
+	}
+	discoveries.docs[issuer] = doc
+	discoveries.Unlock()
+	return doc, nil
+}
+
+func fetchDiscovery(ctx context.Context, issuer string) (*Discovery, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, issuer+discoveryPath, nil)
+	if err != nil {
+		return nil, &DiscoveryError{Issuer: issuer, err: err}
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := httpClient(ctx).Do(req)
+	if err != nil {
+		return nil, &DiscoveryError{Issuer: issuer, err: err}
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, &DiscoveryError{Issuer: issuer, err: err}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &DiscoveryError{Issuer: issuer, StatusCode: resp.StatusCode, err: fmt.Errorf("%s", redactBody(body))}
+	}
+
+	var doc Discovery
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, &DiscoveryError{Issuer: issuer, err: err}
+	}
+	// The issuer must be the one the document was fetched for, see OpenID
+	// Connect Discovery section 4.3
+	if strings.TrimSuffix(doc.Issuer, "/") != issuer {
+		return nil, &DiscoveryError{Issuer: issuer, err: fmt.Errorf("document is for issuer %q", doc.Issuer)}
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" {
+		return nil, &DiscoveryError{Issuer: issuer, err: fmt.Errorf("document is missing endpoints")}
+	}
+	return &doc, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Create a new OAuth2 dialog for the OpenID Connect provider issuer, with a
+// copy of conf whose endpoints are set from its discovery document, see
+// Discover. Responses are checked as with WithIssuer, PKCE is used if the
+// provider supports S256, and its revocation and device authorization
+// endpoints are set if it has them; opts are applied after, so they can
+// override them. When the provider doesn't support
+// form_post, responses including a token are captured from the fragment.
+func NewFromDiscovery(ctx context.Context, issuer string, conf oauth2.Config, opts ...Option) (*Dialog, error) {
+	doc, err := Discover(ctx, issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	conf.Endpoint.AuthURL = doc.AuthorizationEndpoint
+	conf.Endpoint.TokenURL = doc.TokenEndpoint
+	discovered := []Option{WithIssuer(doc.Issuer, doc.AuthorizationResponseIssParameterSupported)}
+	if contains(doc.CodeChallengeMethodsSupported, "S256") {
+		discovered = append(discovered, WithPKCE())
+	}
+	if doc.RevocationEndpoint != "" {
+		discovered = append(discovered, WithRevocationURL(doc.RevocationEndpoint))
+	}
+	if doc.DeviceAuthorizationEndpoint != "" {
+		discovered = append(discovered, WithDeviceAuthURL(doc.DeviceAuthorizationEndpoint))
+	}
+
+	d := NewFromConfig(conf, append(discovered, opts...)...)
+	if rt, err := parseResponseType(d.responseType); err == nil && (rt["token"] || rt["id_token"]) &&
+		len(doc.ResponseModesSupported) > 0 && !contains(doc.ResponseModesSupported, "form_post") {
+		d.fragment = true
+	}
+	return d, nil
+}
@@ -0,0 +1,94 @@
+package oauthdialog
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+// Start a provider serving a discovery document, counting its fetches. modify
+// may change the document before it is served.
+func discoveryServer(t *testing.T, modify func(doc *Discovery)) (*httptest.Server, *int32) {
+	var fetches int32
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != discoveryPath {
+			http.NotFound(w, r)
+			return
+		}
+		atomic.AddInt32(&fetches, 1)
+		doc := &Discovery{
+			Issuer:                        srv.URL,
+			AuthorizationEndpoint:         srv.URL + "/auth",
+			TokenEndpoint:                 srv.URL + "/token",
+			RevocationEndpoint:            srv.URL + "/revoke",
+			ResponseModesSupported:        []string{"query", "fragment"},
+			CodeChallengeMethodsSupported: []string{"plain", "S256"},
+		}
+		if modify != nil {
+			modify(doc)
+		}
+		json.NewEncoder(w).Encode(doc)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &fetches
+}
+
+func TestNewFromDiscovery(t *testing.T) {
+	srv, fetches := discoveryServer(t, nil)
+	d, err := NewFromDiscovery(context.Background(), srv.URL, oauth2.Config{ClientID: "id"},
+		WithResponseType("code", "id_token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.config.Endpoint.AuthURL != srv.URL+"/auth" || d.config.Endpoint.TokenURL != srv.URL+"/token" {
+		t.Errorf("got endpoint %+v", d.config.Endpoint)
+	}
+	if !d.pkce {
+		t.Error("PKCE not enabled")
+	}
+	if d.revocationURL != srv.URL+"/revoke" {
+		t.Errorf("got revocation URL %q", d.revocationURL)
+	}
+	if !d.fragment {
+		t.Error("fragment capture not enabled without form_post")
+	}
+
+	if _, err := NewFromDiscovery(context.Background(), srv.URL+"/", oauth2.Config{}); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(fetches); n != 1 {
+		t.Errorf("document fetched %v times", n)
+	}
+}
+
+func TestDiscoveryErrors(t *testing.T) {
+	for name, modify := range map[string]func(doc *Discovery){
+		"issuer mismatch":   func(doc *Discovery) { doc.Issuer = "https://attacker.example" },
+		"missing endpoints": func(doc *Discovery) { doc.TokenEndpoint = "" },
+	} {
+		t.Run(name, func(t *testing.T) {
+			srv, _ := discoveryServer(t, modify)
+			_, err := Discover(context.Background(), srv.URL)
+			var discoveryErr *DiscoveryError
+			if !errors.As(err, &discoveryErr) || discoveryErr.Issuer != srv.URL {
+				t.Fatalf("got error %v, want a *DiscoveryError", err)
+			}
+		})
+	}
+
+	t.Run("status", func(t *testing.T) {
+		srv, _ := discoveryServer(t, nil)
+		_, err := Discover(context.Background(), srv.URL+"/missing")
+		var discoveryErr *DiscoveryError
+		if !errors.As(err, &discoveryErr) || discoveryErr.StatusCode != http.StatusNotFound {
+			t.Fatalf("got error %v, want a *DiscoveryError with status 404", err)
+		}
+	})
+}
@@ -0,0 +1,55 @@
+package oauthdialog
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWithDryRun(t *testing.T) {
+	srv, form := tokenServer(t, `{"access_token":"access","token_type":"bearer"}`)
+	conf := testConfig()
+	conf.Endpoint.TokenURL = srv.URL
+	tok, err := New(conf, WithDryRun("canned")).Token(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.AccessToken != "access" {
+		t.Errorf("got access token %q", tok.AccessToken)
+	}
+	if code := form.Get("code"); code != "canned" {
+		t.Errorf("exchanged code %q, want the canned one", code)
+	}
+}
+
+func TestWithDryRunTLS(t *testing.T) {
+	res, err := New(testConfig(), WithTLS(), WithDryRun("canned")).OpenContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Code != "canned" {
+		t.Errorf("got code %q", res.Code)
+	}
+}
+
+func TestDryRunOpenerWithoutRedirectURI(t *testing.T) {
+	if err := DryRunOpener("canned")(context.Background(), "https://provider.example/auth"); err == nil {
+		t.Error("no error without a redirect URI")
+	}
+}
+
+func TestWithDryRunFragmentCapture(t *testing.T) {
+	for _, opts := range [][]Option{
+		{WithFragmentCapture()},
+		{WithFragmentCapture(), WithFlowSecret()},
+	} {
+		d := New(testConfig(), append(opts, WithDryRun("canned"), WithTimeout(2*time.Second))...)
+		res, err := d.OpenContext(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Code != "canned" || res.Mode != DeliveryFragment {
+			t.Errorf("got code %q delivered by %v, want the canned one through the bridge", res.Code, res.Mode)
+		}
+	}
+}
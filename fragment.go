@@ -0,0 +1,126 @@
+package oauthdialog
+
+import (
+	"crypto/subtle"
+	"golang.org/x/oauth2"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Parameter marking the request made by the fragment bridge, so that it can
+// be told apart from the provider's redirect to the callback path.
+const fragmentMarker = "oauthdialog_fragment"
+
+// Page served on the provider's redirect when fragment capture is enabled.
+// The server never sees the fragment, so the page posts its parameters, along
+// with those of the query, back to the callback path with the marker set to
+// the flow secret, if any, or 1. POSTing keeps the tokens out of the browser
+// history and the server logs.
+const fragmentBridge = `<!DOCTYPE html>
+<html><meta charset="utf-8">
+<noscript><p>JavaScript is required to complete the authorization.</p></noscript>
+<form id="f" method="post"></form>
+<script>
+var params = new URLSearchParams(location.search);
+new URLSearchParams(location.hash.slice(1)).forEach(function(v, k) { params.set(k, v); });
+params.set("` + fragmentMarker + `", "{{marker}}");
+var form = document.getElementById("f");
+form.action = location.pathname;
+params.forEach(function(v, k) {
+	var input = document.createElement("input");
+	input.type = "hidden";
+	input.name = k;
+	input.value = v;
+	form.appendChild(input);
+});
+history.replaceState(null, "", location.pathname);
+form.submit();
+</script>
+</html>
+`
+
+// Serve the bridge page, posting back marker. It must be URL-safe.
+func serveFragmentBridge(w http.ResponseWriter, marker string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Write([]byte(strings.Replace(fragmentBridge, "{{marker}}", marker, 1)))
+}
+
+// Get the marker the bridge page served for f must post back. The flow
+// secret is only handed out once, to the browser following the provider's
+// redirect, and later loads of the bridge get a marker which is rejected.
+func (d *Dialog) bridgeMarker(f *flow) string {
+	if f.secret == "" {
+		return "1"
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if f.secretIssued {
+		return "0"
+	}
+	f.secretIssued = true
+	return f.secret
+}
+
+// Matches the marker in the bridge page.
+var bridgeMarkerRegexp = regexp.MustCompile(fragmentMarker + `", "([^"]*)"`)
+
+// Get the marker the bridge page posts back, if page is the bridge page.
+func bridgePageMarker(page []byte) (string, bool) {
+	m := bridgeMarkerRegexp.FindSubmatch(page)
+	if m == nil {
+		return "", false
+	}
+	return string(m[1]), true
+}
+
+// Check the marker posted back by the bridge page.
+func (f *flow) validMarker(marker string) bool {
+	if f.secret == "" {
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(marker), []byte(f.secret)) == 1
+}
+
+// Check whether req was made by the fragment bridge.
+func isFragmentBridge(req *http.Request) bool {
+	return req.Method == http.MethodPost && req.PostForm.Get(fragmentMarker) != ""
+}
+
+// Parse the lifetime of an access token returned on the callback, in
+// seconds. Some providers quote it, which is tolerated.
+func parseExpiresIn(s string) (time.Duration, bool) {
+	s = strings.Trim(strings.TrimSpace(s), `"'`)
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+// Get when an access token with the given expires_in expires, or the zero
+// time if it can't be parsed.
+func expiry(expiresIn string) time.Time {
+	d, ok := parseExpiresIn(expiresIn)
+	if !ok {
+		return time.Time{}
+	}
+	return time.Now().Add(d)
+}
+
+// Get the access token returned on the callback, as in the implicit flow,
+// or nil if there is none.
+func (r *Result) Token() *oauth2.Token {
+	if r.AccessToken == "" {
+		return nil
+	}
+	return &oauth2.Token{
+		AccessToken: r.AccessToken,
+		TokenType:   r.TokenType,
+		Expiry:      r.Expiry,
+	}
+}
@@ -0,0 +1,209 @@
+package oauthdialog
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Post form to the callback of d, as the bridge page or another process
+// would.
+func postCallback(d *Dialog, callbackURL string, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, callbackURL, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	d.CallbackHandler().ServeHTTP(rec, req)
+	return rec
+}
+
+// Load the bridge page at callbackURL and get the marker it posts back.
+func loadBridge(t *testing.T, d *Dialog, callbackURL string) string {
+	rec := httptest.NewRecorder()
+	d.CallbackHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, callbackURL, nil))
+	m := bridgeMarkerRegexp.FindStringSubmatch(rec.Body.String())
+	if m == nil {
+		t.Fatalf("no marker in bridge page %q", rec.Body.String())
+	}
+	return m[1]
+}
+
+func TestFlowSecret(t *testing.T) {
+	d := New(testConfig(), WithFragmentCapture(), WithFlowSecret())
+	cb := callbackURL(t, d, nil)
+	u, _ := url.Parse(cb)
+	state := u.Query().Get("state")
+
+	marker := loadBridge(t, d, cb)
+	if marker == "1" {
+		t.Fatal("bridge page has no flow secret")
+	}
+	if again := loadBridge(t, d, cb); again == marker {
+		t.Error("flow secret served twice")
+	}
+
+	rec := postCallback(d, cb, url.Values{"state": {state}, "code": {"code"}, fragmentMarker: {marker}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %v for the bridge", rec.Code)
+	}
+	res, err := d.Wait(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Code != "code" || res.Mode != DeliveryFragment {
+		t.Errorf("got result %+v", res)
+	}
+}
+
+func TestFlowSecretRejectsReplay(t *testing.T) {
+	for name, form := range map[string]url.Values{
+		"without marker": {"code": {"stolen"}},
+		"wrong marker":   {"code": {"stolen"}, fragmentMarker: {"1"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			d := New(testConfig(), WithFragmentCapture(), WithFlowSecret(), WithTimeout(100*time.Millisecond))
+			cb := callbackURL(t, d, nil)
+			u, _ := url.Parse(cb)
+			form.Set("state", u.Query().Get("state"))
+
+			if rec := postCallback(d, cb, form); rec.Code != http.StatusForbidden {
+				t.Errorf("got status %v, want 403", rec.Code)
+			}
+			if res, err := d.Wait(context.Background()); err != ErrTimeout {
+				t.Errorf("got result %+v and error %v, want ErrTimeout", res, err)
+			}
+		})
+	}
+}
+
+func TestBridgeInitialRequest(t *testing.T) {
+	d := New(testConfig(), WithFragmentCapture(), WithTimeout(100*time.Millisecond))
+	// Even with a complete query, the initial load only serves the bridge
+	cb := callbackURL(t, d, url.Values{"code": {"code"}})
+	rec := httptest.NewRecorder()
+	d.CallbackHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, cb, nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), fragmentMarker) {
+		t.Fatalf("got status %v and page %q, want the bridge", rec.Code, rec.Body.String())
+	}
+	if _, err := d.Wait(context.Background()); err != ErrTimeout {
+		t.Errorf("got error %v, want the initial request not to be delivered", err)
+	}
+}
+
+func TestBridgePromotedRequest(t *testing.T) {
+	d := New(testConfig(), WithFragmentCapture())
+	cb := callbackURL(t, d, nil)
+	u, _ := url.Parse(cb)
+	// The promoted request has the query values along the fragment's
+	marker := loadBridge(t, d, cb)
+	form := url.Values{"state": {u.Query().Get("state")}, "code": {"code"}, "id_token": {"id"}, fragmentMarker: {marker}}
+	if rec := postCallback(d, cb, form); rec.Code != http.StatusOK {
+		t.Fatalf("got status %v for the promoted request", rec.Code)
+	}
+	res, err := d.Wait(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Code != "code" || res.IdToken != "id" {
+		t.Errorf("got result %+v", res)
+	}
+}
+
+func TestParseExpiresIn(t *testing.T) {
+	for s, want := range map[string]time.Duration{
+		"3600":                 time.Hour,
+		`"3600"`:               time.Hour,
+		" '60' ":               time.Minute,
+		"":                     0,
+		"0":                    0,
+		"-5":                   0,
+		"1h":                   0,
+		"3600.5":               0,
+		"2030-01-01T00:00:00Z": 0,
+	} {
+		got, ok := parseExpiresIn(s)
+		if got != want || ok != (want != 0) {
+			t.Errorf("parseExpiresIn(%q) = %v, %v, want %v", s, got, ok, want)
+		}
+	}
+}
+
+func TestCallbackExpiresIn(t *testing.T) {
+	for expiresIn, valid := range map[string]bool{`"3600"`: true, "soon": false} {
+		var logs strings.Builder
+		d := New(testConfig(), WithFragmentCapture(), WithLogger(log.New(&logs, "", 0)))
+		cb := callbackURL(t, d, nil)
+		u, _ := url.Parse(cb)
+		marker := loadBridge(t, d, cb)
+		postCallback(d, cb, url.Values{
+			"state":        {u.Query().Get("state")},
+			"access_token": {"access"},
+			"token_type":   {"bearer"},
+			"expires_in":   {expiresIn},
+			fragmentMarker: {marker},
+		})
+		res, err := d.Wait(context.Background())
+		if err != nil {
+			t.Fatalf("got error %v for expires_in %q", err, expiresIn)
+		}
+		if remaining := time.Until(res.Expiry); valid != (remaining > 59*time.Minute && remaining <= time.Hour) {
+			t.Errorf("got expiry in %v for expires_in %q", remaining, expiresIn)
+		}
+		if warned := strings.Contains(logs.String(), "expires_in"); warned == valid {
+			t.Errorf("got logs %q for expires_in %q", logs.String(), expiresIn)
+		}
+	}
+}
+
+// Follow the implicit flow as a browser would: the provider redirects with
+// the token in the fragment, which the server never sees, then the bridge
+// posts it back.
+func TestBridgeSequence(t *testing.T) {
+	var first *http.Response
+	bridge := func(ctx context.Context, authURL string) error {
+		u, _ := url.Parse(authURL)
+		q := u.Query()
+		go func() {
+			resp, err := http.Get(q.Get("redirect_uri"))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			first = resp
+			m := bridgeMarkerRegexp.FindStringSubmatch(string(body))
+			if resp.StatusCode != http.StatusOK || m == nil {
+				t.Errorf("got status %v and page %q for the first request", resp.StatusCode, body)
+				return
+			}
+			resp, err = http.PostForm(q.Get("redirect_uri"), url.Values{
+				"state":        {q.Get("state")},
+				"access_token": {"access"},
+				"token_type":   {"bearer"},
+				fragmentMarker: {m[1]},
+			})
+			if err == nil {
+				resp.Body.Close()
+			}
+		}()
+		return nil
+	}
+
+	d := New(testConfig(), WithResponseType("token"), WithFragmentCapture(), WithOpener(bridge), WithTimeout(2*time.Second))
+	res, err := d.OpenContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == nil || first.Header.Get("Cache-Control") != "no-store" {
+		t.Errorf("got first response %v, want the bridge page", first)
+	}
+	if tok := res.Token(); tok == nil || tok.AccessToken != "access" {
+		t.Errorf("got token %+v", tok)
+	}
+}
@@ -0,0 +1,86 @@
+package oauthdialog
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strings"
+)
+
+// A response writer compressing the body with gzip. Compression only starts
+// with the body, so that a response without one is sent as is.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz     *gzip.Writer
+	status int
+}
+
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+// Send the header and start compressing, unless already done.
+func (w *gzipResponseWriter) start() {
+	if w.gz != nil {
+		return
+	}
+	// The length of the compressed body isn't known
+	w.Header().Del("Content-Length")
+	w.Header().Set("Content-Encoding", "gzip")
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	w.gz = gzip.NewWriter(w.ResponseWriter)
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	w.start()
+	return w.gz.Write(b)
+}
+
+// Flush what was written so far, e.g. so that the browser can render and run
+// the start of a page while the handler is still writing.
+func (w *gzipResponseWriter) Flush() {
+	w.start()
+	w.gz.Flush()
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Finish the response once the handler returned.
+func (w *gzipResponseWriter) close() error {
+	if w.gz == nil {
+		if w.status != 0 {
+			w.ResponseWriter.WriteHeader(w.status)
+		}
+		return nil
+	}
+	return w.gz.Close()
+}
+
+// Wrap h to compress its response with gzip when the client accepts it.
+func gzipHandler(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(req) {
+			h(w, req)
+			return
+		}
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.close()
+		h(gw, req)
+	}
+}
+
+func acceptsGzip(req *http.Request) bool {
+	for _, enc := range strings.Split(req.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(enc), ";")
+		if strings.TrimSpace(name) == "gzip" && strings.ReplaceAll(params, " ", "") != "q=0" {
+			return true
+		}
+	}
+	return false
+}
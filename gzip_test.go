@@ -0,0 +1,169 @@
+package oauthdialog
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func serveGzip(h http.HandlerFunc, acceptEncoding string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+	rec := httptest.NewRecorder()
+	gzipHandler(h)(rec, req)
+	return rec
+}
+
+func TestGzipHandler(t *testing.T) {
+	rec := serveGzip(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Length", "5")
+		w.Write([]byte("hello"))
+	}, "deflate, gzip")
+	if rec.Header().Get("Content-Encoding") != "gzip" || rec.Header().Get("Content-Length") != "" {
+		t.Fatalf("got headers %v", rec.Header())
+	}
+	gz, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(gz)
+	if err != nil || string(body) != "hello" {
+		t.Errorf("got body %q and error %v", body, err)
+	}
+}
+
+func TestGzipHandlerEmptyBody(t *testing.T) {
+	for status, h := range map[int]http.HandlerFunc{
+		http.StatusOK:        func(w http.ResponseWriter, req *http.Request) {},
+		http.StatusNoContent: func(w http.ResponseWriter, req *http.Request) { w.WriteHeader(http.StatusNoContent) },
+	} {
+		rec := serveGzip(h, "gzip")
+		if rec.Code != status || rec.Body.Len() != 0 || rec.Header().Get("Content-Encoding") != "" {
+			t.Errorf("got status %v, headers %v and %v bytes, want status %v and nothing else", rec.Code, rec.Header(), rec.Body.Len(), status)
+		}
+	}
+}
+
+func TestGzipHandlerNotAccepted(t *testing.T) {
+	for _, acceptEncoding := range []string{"", "deflate", "gzip;q=0"} {
+		rec := serveGzip(func(w http.ResponseWriter, req *http.Request) {
+			w.Write([]byte("hello"))
+		}, acceptEncoding)
+		if rec.Header().Get("Content-Encoding") != "" || rec.Body.String() != "hello" {
+			t.Errorf("got headers %v and body %q for %q", rec.Header(), rec.Body.String(), acceptEncoding)
+		}
+	}
+}
+
+// A handler flushing the start of a page, then finishing it once released.
+func streamingHandler(release <-chan struct{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("start"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-req.Context().Done():
+		}
+		w.Write([]byte(" end"))
+	}
+}
+
+// Serve the callback of d on a test server and request it accepting gzip.
+func getCallback(t *testing.T, d *Dialog, params url.Values) *http.Response {
+	srv := httptest.NewServer(d.CallbackHandler())
+	t.Cleanup(srv.Close)
+	cb, _ := url.Parse(callbackURL(t, d, params))
+	target, _ := url.Parse(srv.URL)
+	cb.Scheme, cb.Host = target.Scheme, target.Host
+	req, _ := http.NewRequest(http.MethodGet, cb.String(), nil)
+	// Set explicitly, the response isn't decompressed by the transport
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestGzipFlushThroughCallbackHandler(t *testing.T) {
+	release := make(chan struct{})
+	d := New(testConfig(), WithGzip())
+	d.SuccessHandler = streamingHandler(release)
+	resp := getCallback(t, d, url.Values{"code": {"code"}})
+	if resp.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("got headers %v", resp.Header)
+	}
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The start arrives while the handler still runs
+	start := make([]byte, len("start"))
+	if _, err := io.ReadFull(gz, start); err != nil || string(start) != "start" {
+		t.Fatalf("got %q and error %v before the handler finished", start, err)
+	}
+	close(release)
+	rest, err := io.ReadAll(gz)
+	if err != nil || string(rest) != " end" {
+		t.Errorf("got %q and error %v", rest, err)
+	}
+}
+
+func TestFlushedHandlerTimeout(t *testing.T) {
+	// Expire the handler timeout once the handler flushed
+	timers := make(chan *time.Timer, 1)
+	t.Cleanup(func() { newTimer = time.NewTimer })
+	newTimer = func(d time.Duration) *time.Timer {
+		timer := time.NewTimer(d)
+		if d == time.Hour {
+			timers <- timer
+		}
+		return timer
+	}
+	d := New(testConfig(), WithGzip(), WithHandlerTimeout(time.Hour))
+	d.SuccessHandler = func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("start"))
+		w.(http.Flusher).Flush()
+		(<-timers).Reset(0)
+		<-req.Context().Done()
+		w.Write([]byte(" end"))
+	}
+
+	resp := getCallback(t, d, url.Values{"code": {"code"}})
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The flushed page is kept, the handler is only told to stop
+	body, err := io.ReadAll(gz)
+	if err != nil || string(body) != "start end" {
+		t.Errorf("got %q and error %v, want the handler's page", body, err)
+	}
+}
+
+func TestGzipCallbackHandler(t *testing.T) {
+	d := New(testConfig(), WithGzip())
+	resp := getCallback(t, d, url.Values{"code": {"code"}})
+	if resp.Header.Get("Content-Encoding") != "gzip" || resp.Header.Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("got headers %v", resp.Header)
+	}
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(gz)
+	if err != nil || !strings.Contains(string(body), defaultSuccessMessage) {
+		t.Errorf("got page %q and error %v", body, err)
+	}
+	if _, err := d.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,34 @@
+package oauthdialog
+
+import "fmt"
+
+// An error returned when the provider's response isn't from the issuer set
+// with WithIssuer, as in mix-up attacks, see RFC 9207.
+type IssuerError struct {
+	Expected string
+	// The iss parameter of the response, empty if it was missing.
+	Got string
+}
+
+func (e *IssuerError) Error() string {
+	if e.Got == "" {
+		return fmt.Sprintf("Response is missing the issuer, expected %q", e.Expected)
+	}
+	return fmt.Sprintf("Response is from issuer %q, expected %q", e.Got, e.Expected)
+}
+
+// Check the iss parameter of the provider's response against the expected
+// issuer, if any.
+func (f *flow) checkIssuer(res *handlerResponse) error {
+	if f.issuer == "" {
+		return nil
+	}
+	if res.Issuer == "" && !f.issuerRequired {
+		return nil
+	}
+	// A simple string comparison, see RFC 9207 section 2.4
+	if res.Issuer != f.issuer {
+		return &IssuerError{Expected: f.issuer, Got: res.Issuer}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package oauthdialog
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+const testIssuer = "https://provider.example"
+
+// Redirect to d's callback with params and wait for the result.
+func callback(t *testing.T, d *Dialog, params url.Values) (*Result, error) {
+	rec := httptest.NewRecorder()
+	d.CallbackHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, callbackURL(t, d, params), nil))
+	return d.Wait(context.Background())
+}
+
+func TestIssuerMatching(t *testing.T) {
+	d := New(testConfig(), WithIssuer(testIssuer, true))
+	res, err := callback(t, d, url.Values{"code": {"code"}, "iss": {testIssuer}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Issuer != testIssuer {
+		t.Errorf("got issuer %q", res.Issuer)
+	}
+}
+
+func TestIssuerMismatching(t *testing.T) {
+	for _, params := range []url.Values{
+		{"code": {"code"}, "iss": {"https://attacker.example"}},
+		{"error": {"access_denied"}, "iss": {"https://attacker.example"}},
+	} {
+		d := New(testConfig(), WithIssuer(testIssuer, false))
+		_, err := callback(t, d, params)
+		var issuerErr *IssuerError
+		if !errors.As(err, &issuerErr) || issuerErr.Got != "https://attacker.example" {
+			t.Errorf("got error %v for %v, want an *IssuerError", err, params)
+		}
+	}
+}
+
+func TestIssuerAbsent(t *testing.T) {
+	d := New(testConfig(), WithIssuer(testIssuer, false))
+	res, err := callback(t, d, url.Values{"code": {"code"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Issuer != "" {
+		t.Errorf("got issuer %q", res.Issuer)
+	}
+
+	d = New(testConfig(), WithIssuer(testIssuer, true))
+	_, err = callback(t, d, url.Values{"code": {"code"}})
+	var issuerErr *IssuerError
+	if !errors.As(err, &issuerErr) || issuerErr.Got != "" {
+		t.Errorf("got error %v, want an *IssuerError", err)
+	}
+}
+
+func TestIssuerUnchecked(t *testing.T) {
+	d := New(testConfig())
+	res, err := callback(t, d, url.Values{"code": {"code"}, "iss": {"https://other.example"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Issuer != "https://other.example" {
+		t.Errorf("got issuer %q", res.Issuer)
+	}
+}
@@ -0,0 +1,120 @@
+package oauthdialog
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Default lifetime of a signed state.
+const defaultStateMaxAge = 10 * time.Minute
+
+var (
+	// ErrInvalidStateToken is returned when a signed state is malformed or
+	// its signature doesn't match.
+	ErrInvalidStateToken = errors.New("Invalid signed state")
+	// ErrStateExpired is returned when a signed state has expired.
+	ErrStateExpired = errors.New("Signed state expired")
+)
+
+// Encode and sign a JWT with the given header and claims. sign gets the
+// signing input and returns the signature.
+func encodeJWT(header, claims map[string]interface{}, sign func([]byte) ([]byte, error)) (string, error) {
+	h, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	input := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	sig, err := sign([]byte(input))
+	if err != nil {
+		return "", err
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+func hs256(key []byte) func([]byte) ([]byte, error) {
+	return func(input []byte) ([]byte, error) {
+		mac := hmac.New(sha256.New, key)
+		mac.Write(input)
+		return mac.Sum(nil), nil
+	}
+}
+
+// Generate a state signed with key, carrying claims, a random jti claim used
+// as CSRF token and iat/exp claims.
+func signState(key []byte, claims map[string]interface{}, maxAge time.Duration, n int) (string, error) {
+	jti, err := generateState(n)
+	if err != nil {
+		return "", err
+	}
+	if maxAge <= 0 {
+		maxAge = defaultStateMaxAge
+	}
+
+	now := time.Now()
+	all := make(map[string]interface{}, len(claims)+3)
+	for k, v := range claims {
+		all[k] = v
+	}
+	all["jti"] = jti
+	all["iat"] = now.Unix()
+	all["exp"] = now.Add(maxAge).Unix()
+
+	header := map[string]interface{}{"alg": "HS256", "typ": "JWT"}
+	return encodeJWT(header, all, hs256(key))
+}
+
+// Verify a state signed with WithSignedState and get its claims. The
+// signature and the exp and iat claims are checked.
+func ParseSignedState(key []byte, state string) (map[string]interface{}, error) {
+	parts := strings.Split(state, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidStateToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	h, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(h, &header) != nil || header.Alg != "HS256" {
+		return nil, ErrInvalidStateToken
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidStateToken
+	}
+	expected, _ := hs256(key)([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(sig, expected) {
+		return nil, ErrInvalidStateToken
+	}
+
+	var claims map[string]interface{}
+	c, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || json.Unmarshal(c, &claims) != nil {
+		return nil, ErrInvalidStateToken
+	}
+
+	now := time.Now().Unix()
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, ErrInvalidStateToken
+	}
+	if int64(exp) < now {
+		return nil, ErrStateExpired
+	}
+	if iat, ok := claims["iat"].(float64); !ok || int64(iat) > now+60 {
+		return nil, ErrInvalidStateToken
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,78 @@
+package oauthdialog
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSignedState(t *testing.T) {
+	key := []byte("key")
+	state, err := signState(key, map[string]interface{}{"return_to": "/home"}, time.Minute, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := ParseSignedState(key, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims["return_to"] != "/home" || claims["jti"] == "" {
+		t.Errorf("got claims %v", claims)
+	}
+
+	now := time.Now().Unix()
+	sign := func(header, claims map[string]interface{}) string {
+		s, err := encodeJWT(header, claims, hs256(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	hs256Header := map[string]interface{}{"alg": "HS256", "typ": "JWT"}
+	parts := strings.Split(state, ".")
+	tests := []struct {
+		name  string
+		key   []byte
+		state string
+		err   error
+	}{
+		{"wrong key", []byte("other"), state, ErrInvalidStateToken},
+		{"bad signature", key, parts[0] + "." + parts[1] + ".AAAA", ErrInvalidStateToken},
+		{"tampered claims", key, parts[0] + "." + strings.Split(sign(hs256Header, map[string]interface{}{"exp": now + 60, "iat": now}), ".")[1] + "." + parts[2], ErrInvalidStateToken},
+		{"not a JWT", key, "state", ErrInvalidStateToken},
+		{"other algorithm", key, sign(map[string]interface{}{"alg": "none"}, map[string]interface{}{"exp": now + 60, "iat": now}), ErrInvalidStateToken},
+		{"expired", key, sign(hs256Header, map[string]interface{}{"exp": now - 60, "iat": now - 120}), ErrStateExpired},
+		{"missing exp", key, sign(hs256Header, map[string]interface{}{"iat": now}), ErrInvalidStateToken},
+		{"non-numeric exp", key, sign(hs256Header, map[string]interface{}{"exp": "later", "iat": now}), ErrInvalidStateToken},
+		{"future iat", key, sign(hs256Header, map[string]interface{}{"exp": now + 600, "iat": now + 300}), ErrInvalidStateToken},
+		{"missing iat", key, sign(hs256Header, map[string]interface{}{"exp": now + 60}), ErrInvalidStateToken},
+	}
+	for _, test := range tests {
+		claims, err := ParseSignedState(test.key, test.state)
+		if err != test.err {
+			t.Errorf("%v: got claims %v and error %v, want %v", test.name, claims, err, test.err)
+		}
+	}
+}
+
+func TestSignStateClaims(t *testing.T) {
+	key := []byte("key")
+	claims := map[string]interface{}{"tenant": "t1"}
+	state, err := signState(key, claims, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(claims, map[string]interface{}{"tenant": "t1"}) {
+		t.Errorf("caller's claims changed to %v", claims)
+	}
+	parsed, err := ParseSignedState(key, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp, _ := parsed["exp"].(float64)
+	iat, _ := parsed["iat"].(float64)
+	if time.Duration(exp-iat)*time.Second != defaultStateMaxAge {
+		t.Errorf("got a lifetime of %v, want the default", time.Duration(exp-iat)*time.Second)
+	}
+}
@@ -0,0 +1,74 @@
+package oauthdialog
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// ErrNoInterfaceAddress is returned when the interface set with
+// WithInterface has no usable address.
+var ErrNoInterfaceAddress = errors.New("No usable address on interface")
+
+// Start listening for the provider's redirect.
+func (d *Dialog) listen() (net.Listener, error) {
+	if d.listener != nil {
+		return d.listener, nil
+	}
+
+	host := "127.0.0.1"
+	if d.iface != "" {
+		ip, err := interfaceAddr(d.iface)
+		if err != nil {
+			return nil, err
+		}
+		host = ip.String()
+	}
+
+	return net.Listen("tcp", net.JoinHostPort(host, "0"))
+}
+
+// Get the address of a network interface, preferring IPv4.
+func interfaceAddr(name string) (net.IP, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("Interface %q not found: %w", name, err)
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+
+	var ipv6 net.IP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if ipNet.IP.To4() != nil {
+			return ipNet.IP, nil
+		}
+		if ipv6 == nil {
+			ipv6 = ipNet.IP
+		}
+	}
+
+	if ipv6 == nil {
+		return nil, fmt.Errorf("%w %q", ErrNoInterfaceAddress, name)
+	}
+	return ipv6, nil
+}
+
+// Serve HTTP requests from ln in the background, sending the error stopping
+// the server to the returned channel. The browser can be opened right away:
+// the listener being bound, connections made before the server accepts them
+// wait in the backlog instead of being refused.
+func serve(s *http.Server, ln net.Listener) <-chan error {
+	served := make(chan error, 1)
+	go func() {
+		served <- s.Serve(ln)
+	}()
+	return served
+}
@@ -0,0 +1,185 @@
+package oauthdialog
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Connect to the server as soon as the opener runs, before it may have
+// started serving: no connection may be refused.
+func TestServeBeforeAccept(t *testing.T) {
+	const connections = 50
+	var errs []error
+	var mu sync.Mutex
+	hammer := func(ctx context.Context, authURL string) error {
+		u, err := url.Parse(authURL)
+		if err != nil {
+			return err
+		}
+		redirectURI := u.Query().Get("redirect_uri")
+		var wg sync.WaitGroup
+		for i := 0; i < connections; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				resp, err := http.Get(redirectURI + "/?state=other")
+				if err == nil {
+					resp.Body.Close()
+				}
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}()
+		}
+		wg.Wait()
+		return redirectOpener(url.Values{"code": {"code"}})(ctx, authURL)
+	}
+
+	d := New(testConfig(), WithOpener(hammer))
+	if _, err := d.OpenContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for _, err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if len(errs) != connections {
+		t.Errorf("got %v responses, want %v", len(errs), connections)
+	}
+}
+
+func TestUnixListener(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "callback.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skip(err)
+	}
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+
+	conf := testConfig()
+	conf.RedirectURL = "http://proxy.example/callback"
+	d := New(conf, WithListener(ln), WithOpener(func(ctx context.Context, authURL string) error {
+		u, err := url.Parse(authURL)
+		if err != nil {
+			return err
+		}
+		if redirectURI := u.Query().Get("redirect_uri"); redirectURI != conf.RedirectURL {
+			t.Errorf("got redirect URI %q, want the config's", redirectURI)
+		}
+		go func() {
+			resp, err := client.Get("http://proxy.example/callback?code=code&state=" + u.Query().Get("state"))
+			if err == nil {
+				resp.Body.Close()
+			}
+		}()
+		return nil
+	}))
+	res, err := d.OpenContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Code != "code" {
+		t.Errorf("got code %q", res.Code)
+	}
+}
+
+func TestListenerClosedMidWait(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := New(testConfig(), WithListener(ln), WithOpener(func(ctx context.Context, url string) error {
+		time.AfterFunc(50*time.Millisecond, func() { ln.Close() })
+		return nil
+	}))
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := d.OpenContext(context.Background())
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrServerClosed) {
+			t.Errorf("got error %v, want ErrServerClosed", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("OpenContext didn't return once the listener closed")
+	}
+}
+
+// A writer sending each write on a channel.
+type chanWriter chan string
+
+func (w chanWriter) Write(p []byte) (int, error) {
+	select {
+	case w <- string(p):
+	default:
+	}
+	return len(p), nil
+}
+
+// Get an opener sending garbage to the local server, which fails the TLS
+// handshake, then completing the flow.
+func garbageOpener() Opener {
+	dryRun := DryRunOpener("code")
+	return func(ctx context.Context, authURL string) error {
+		u, _ := url.Parse(authURL)
+		redirectURL, _ := url.Parse(u.Query().Get("redirect_uri"))
+		conn, err := net.Dial("tcp", redirectURL.Host)
+		if err != nil {
+			return err
+		}
+		conn.Write([]byte("\x00\x00\x00\x00\x00\x00\x00\x00"))
+		// Wait for the server to hang up
+		io.Copy(io.Discard, conn)
+		conn.Close()
+		return dryRun(ctx, authURL)
+	}
+}
+
+func TestWithServerErrorLog(t *testing.T) {
+	logs := make(chanWriter, 1)
+	d := New(testConfig(), WithTLS(), WithServerErrorLog(log.New(logs, "", 0)), WithOpener(garbageOpener()))
+	if _, err := d.OpenContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-logs:
+		if !strings.Contains(msg, "TLS handshake error") {
+			t.Errorf("got log %q, want a TLS handshake error", msg)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("the server error wasn't logged")
+	}
+}
+
+func TestServerErrorLogDiscarded(t *testing.T) {
+	logs := make(chanWriter, 1)
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(logs)
+	d := New(testConfig(), WithTLS(), WithOpener(garbageOpener()))
+	if _, err := d.OpenContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-logs:
+		t.Errorf("got log %q, want server errors discarded", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
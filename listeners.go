@@ -0,0 +1,79 @@
+package oauthdialog
+
+import (
+	"sort"
+	"sync"
+)
+
+// The local servers of all dialogs, by dialog, when tracking is enabled with
+// TrackListeners.
+var tracked struct {
+	sync.Mutex
+	enabled bool
+	servers map[*Dialog]string
+}
+
+// Enable or disable the package-level tracking of the local servers started
+// by dialogs, so that servers of abandoned dialogs can be listed with
+// ActiveListeners and stopped with CloseAll, e.g. on application shutdown.
+// Tracking is disabled by default and only servers started while it is
+// enabled are tracked; disabling it forgets them without stopping them.
+func TrackListeners(enabled bool) {
+	tracked.Lock()
+	defer tracked.Unlock()
+	tracked.enabled = enabled
+	if !enabled {
+		tracked.servers = nil
+	}
+}
+
+// Get the addresses of the running local servers tracked since
+// TrackListeners was enabled, sorted.
+func ActiveListeners() []string {
+	tracked.Lock()
+	defer tracked.Unlock()
+	addrs := make([]string, 0, len(tracked.servers))
+	for _, addr := range tracked.servers {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+// Stop all the tracked local servers, as Close does for each dialog, failing
+// any flow waiting for them with ErrServerClosed. The first error is
+// returned, after all servers were stopped.
+func CloseAll() error {
+	tracked.Lock()
+	dialogs := make([]*Dialog, 0, len(tracked.servers))
+	for d := range tracked.servers {
+		dialogs = append(dialogs, d)
+	}
+	tracked.Unlock()
+
+	var first error
+	for _, d := range dialogs {
+		if err := d.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func track(d *Dialog, addr string) {
+	tracked.Lock()
+	defer tracked.Unlock()
+	if !tracked.enabled {
+		return
+	}
+	if tracked.servers == nil {
+		tracked.servers = make(map[*Dialog]string)
+	}
+	tracked.servers[d] = addr
+}
+
+func untrack(d *Dialog) {
+	tracked.Lock()
+	defer tracked.Unlock()
+	delete(tracked.servers, d)
+}
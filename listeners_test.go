@@ -0,0 +1,49 @@
+package oauthdialog
+
+import (
+	"net"
+	"testing"
+)
+
+func TestCloseAll(t *testing.T) {
+	TrackListeners(true)
+	t.Cleanup(func() { TrackListeners(false) })
+
+	// Abandoned dialogs, never waited for nor closed
+	const dialogs = 3
+	for i := 0; i < dialogs; i++ {
+		if _, err := New(testConfig()).Prepare(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	addrs := ActiveListeners()
+	if len(addrs) != dialogs {
+		t.Fatalf("got listeners %q, want %v", addrs, dialogs)
+	}
+
+	if err := CloseAll(); err != nil {
+		t.Fatal(err)
+	}
+	if active := ActiveListeners(); len(active) != 0 {
+		t.Errorf("got listeners %q after CloseAll", active)
+	}
+	for _, addr := range addrs {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			t.Errorf("port still bound: %v", err)
+			continue
+		}
+		ln.Close()
+	}
+}
+
+func TestTrackListenersDisabled(t *testing.T) {
+	d := New(testConfig())
+	if _, err := d.Prepare(); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if active := ActiveListeners(); len(active) != 0 {
+		t.Errorf("got listeners %q without tracking", active)
+	}
+}
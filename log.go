@@ -0,0 +1,34 @@
+package oauthdialog
+
+import (
+	"net/url"
+)
+
+// Parameters whose values must never be logged.
+var secretParams = map[string]bool{
+	"code":          true,
+	"state":         true,
+	"access_token":  true,
+	"refresh_token": true,
+	"id_token":      true,
+	// Carries the flow secret, see WithFlowSecret
+	fragmentMarker: true,
+}
+
+func (d *Dialog) logf(format string, v ...interface{}) {
+	if d.logger != nil {
+		d.logger.Printf(format, v...)
+	}
+}
+
+// Get an encoded copy of params with secret values redacted.
+func redactParams(params url.Values) string {
+	redacted := make(url.Values, len(params))
+	for k, v := range params {
+		if secretParams[k] {
+			v = []string{"REDACTED"}
+		}
+		redacted[k] = v
+	}
+	return redacted.Encode()
+}
@@ -0,0 +1,47 @@
+package oauthdialog
+
+import (
+	"context"
+	"log"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRedactParams(t *testing.T) {
+	params := url.Values{
+		"code":          {"secret-code"},
+		"state":         {"secret-state"},
+		"access_token":  {"secret-access"},
+		"refresh_token": {"secret-refresh"},
+		"id_token":      {"secret-id"},
+		fragmentMarker:  {"secret-marker"},
+		"error":         {"access_denied"},
+	}
+	redacted := redactParams(params)
+	if strings.Contains(redacted, "secret") {
+		t.Errorf("got %q, want the secrets redacted", redacted)
+	}
+	if q, _ := url.ParseQuery(redacted); q.Get("error") != "access_denied" || q.Get(fragmentMarker) != "REDACTED" {
+		t.Errorf("got %q", redacted)
+	}
+}
+
+func TestFlowSecretNotLogged(t *testing.T) {
+	var logs strings.Builder
+	d := New(testConfig(), WithFragmentCapture(), WithFlowSecret(), WithLogger(log.New(&logs, "", 0)), WithTimeout(time.Second))
+	cb := callbackURL(t, d, nil)
+	u, _ := url.Parse(cb)
+	marker := loadBridge(t, d, cb)
+
+	// The bridge posts back a fragment without code nor error
+	postCallback(d, cb, url.Values{"state": {u.Query().Get("state")}, fragmentMarker: {marker}})
+	d.Wait(context.Background())
+	if !strings.Contains(logs.String(), "without code nor error") {
+		t.Fatalf("got logs %q", logs.String())
+	}
+	if strings.Contains(logs.String(), marker) {
+		t.Errorf("flow secret logged: %q", logs.String())
+	}
+}
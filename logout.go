@@ -0,0 +1,77 @@
+package oauthdialog
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+)
+
+const logoutPath = "/logout"
+
+// ErrServerClosed is returned when the local server isn't running or stops
+// before receiving the provider's redirect.
+var ErrServerClosed = errors.New("Server closed")
+
+// Get the URL the provider should redirect to after the user signed out, to
+// be used as the post-logout redirect URI. It has the scheme and host of the
+// redirect URI, whatever its path. It is only valid after a successful Open
+// with WithKeepAlive.
+func (d *Dialog) LogoutRedirectURL() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.server == nil || d.redirectURL == "" {
+		return ""
+	}
+	u, err := url.Parse(d.redirectURL)
+	if err != nil {
+		return ""
+	}
+	return (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: logoutPath}).String()
+}
+
+// Wait for the provider to redirect to LogoutRedirectURL, then stop the local
+// server. The dialog must have been opened with WithKeepAlive.
+func (d *Dialog) WaitForLogout(ctx context.Context) error {
+	d.mu.Lock()
+	running := d.server != nil
+	d.mu.Unlock()
+	if !running {
+		return ErrServerClosed
+	}
+	defer d.Close()
+
+	select {
+	case <-d.logout:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stop the local server kept running by WithKeepAlive.
+func (d *Dialog) Close() error {
+	d.mu.Lock()
+	server, ln := d.server, d.serverListener
+	d.server, d.serverListener = nil, nil
+	d.mu.Unlock()
+	untrack(d)
+
+	if server == nil {
+		return nil
+	}
+	err := server.Close()
+	// Serve may not have started yet, in which case the server doesn't know
+	// the listener: close it too so that the port is released on return
+	ln.Close()
+	return err
+}
+
+func (d *Dialog) serveLogout(w http.ResponseWriter, req *http.Request) {
+	select {
+	case d.logout <- struct{}{}:
+	default:
+	}
+
+	defaultSuccessHandler(w, req)
+}
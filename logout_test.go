@@ -0,0 +1,65 @@
+package oauthdialog
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestWaitForLogoutWithRedirectPath(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := New(testConfig(),
+		WithListener(ln),
+		WithRedirectURL("http://"+ln.Addr().String()+"/cb"),
+		WithKeepAlive(),
+		WithOpener(redirectOpener(url.Values{"code": {"code"}})),
+	)
+	defer d.Close()
+	if _, err := d.OpenContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	logoutURL := d.LogoutRedirectURL()
+	if logoutURL != "http://"+ln.Addr().String()+logoutPath {
+		t.Fatalf("got logout URL %q", logoutURL)
+	}
+	go func() {
+		resp, err := http.Get(logoutURL)
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := d.WaitForLogout(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKeepAliveReopen(t *testing.T) {
+	d := New(testConfig(), WithKeepAlive(), WithOpener(redirectOpener(url.Values{"code": {"code"}})))
+	defer d.Close()
+	first, err := d.OpenContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := d.OpenContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.RedirectURL == second.RedirectURL {
+		t.Fatalf("both flows used %v", first.RedirectURL)
+	}
+
+	resp, err := http.Get(first.RedirectURL)
+	if err == nil {
+		resp.Body.Close()
+		t.Errorf("first server still answers with status %v", resp.StatusCode)
+	}
+}
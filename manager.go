@@ -0,0 +1,85 @@
+package oauthdialog
+
+import (
+	"context"
+	"errors"
+	"golang.org/x/oauth2"
+	"sync"
+)
+
+// Default maximum number of dialogs a Manager opens at the same time.
+const DefaultMaxConcurrentFlows = 4
+
+var (
+	// ErrUnknownProvider is returned when opening a dialog for a provider
+	// that hasn't been registered.
+	ErrUnknownProvider = errors.New("Unknown provider")
+	// ErrTooManyFlows is returned when opening a dialog while a Manager
+	// already has MaxConcurrentFlows dialogs open.
+	ErrTooManyFlows = errors.New("Too many dialogs open")
+)
+
+// A set of named OAuth2 providers, e.g. for a "connect your accounts" screen.
+// Each call to Open uses a new Dialog with its own listener, so a Manager can
+// be used concurrently.
+type Manager struct {
+	// Maximum number of dialogs open at the same time. Zero or less means no
+	// limit.
+	MaxConcurrentFlows int
+
+	configs map[string]*oauth2.Config
+	opts    []Option
+
+	mu     sync.Mutex
+	active int
+}
+
+// Create a new manager for the given providers. The options are applied to
+// each dialog, so they must not hold per-dialog resources such as the
+// listener passed to WithListener. WithKeepAlive has no effect: each dialog
+// is closed once Open returns.
+func NewManager(configs map[string]*oauth2.Config, opts ...Option) *Manager {
+	m := &Manager{
+		MaxConcurrentFlows: DefaultMaxConcurrentFlows,
+		configs:            make(map[string]*oauth2.Config, len(configs)),
+		opts:               opts,
+	}
+	for name, conf := range configs {
+		m.configs[name] = conf
+	}
+	return m
+}
+
+// Open a dialog for the named provider.
+func (m *Manager) Open(ctx context.Context, provider string, opts ...oauth2.AuthCodeOption) (*Result, error) {
+	conf, ok := m.configs[provider]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+
+	if err := m.acquire(); err != nil {
+		return nil, err
+	}
+	defer m.release()
+
+	// The caller never sees the dialog, nothing could close it later
+	d := New(conf, m.opts...)
+	defer d.Close()
+	return d.OpenContext(ctx, opts...)
+}
+
+func (m *Manager) acquire() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.MaxConcurrentFlows > 0 && m.active >= m.MaxConcurrentFlows {
+		return ErrTooManyFlows
+	}
+	m.active++
+	return nil
+}
+
+func (m *Manager) release() {
+	m.mu.Lock()
+	m.active--
+	m.mu.Unlock()
+}
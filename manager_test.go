@@ -0,0 +1,72 @@
+package oauthdialog
+
+import (
+	"context"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+func TestManagerMaxConcurrentFlows(t *testing.T) {
+	// Block once opened until the dialog is done, then act as the provider
+	var blocking int32 = 1
+	started := make(chan struct{})
+	redirect := redirectOpener(url.Values{"code": {"code"}})
+	opener := func(ctx context.Context, url string) error {
+		if atomic.LoadInt32(&blocking) == 0 {
+			return redirect(ctx, url)
+		}
+		started <- struct{}{}
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	m := NewManager(map[string]*oauth2.Config{"test": testConfig()}, WithOpener(opener))
+	m.MaxConcurrentFlows = 2
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, m.MaxConcurrentFlows)
+	for i := 0; i < m.MaxConcurrentFlows; i++ {
+		go func() {
+			_, err := m.Open(ctx, "test")
+			done <- err
+		}()
+		<-started
+	}
+
+	if _, err := m.Open(context.Background(), "test"); err != ErrTooManyFlows {
+		t.Errorf("got error %v over the limit, want ErrTooManyFlows", err)
+	}
+
+	cancel()
+	for i := 0; i < m.MaxConcurrentFlows; i++ {
+		<-done
+	}
+	atomic.StoreInt32(&blocking, 0)
+	if _, err := m.Open(context.Background(), "test"); err != nil {
+		t.Errorf("got error %v once the dialogs closed", err)
+	}
+}
+
+func TestManagerUnknownProvider(t *testing.T) {
+	m := NewManager(nil)
+	if _, err := m.Open(context.Background(), "test"); err != ErrUnknownProvider {
+		t.Errorf("got error %v, want ErrUnknownProvider", err)
+	}
+}
+
+func TestManagerKeepAlive(t *testing.T) {
+	TrackListeners(true)
+	t.Cleanup(func() { TrackListeners(false) })
+	m := NewManager(map[string]*oauth2.Config{"test": testConfig()},
+		WithKeepAlive(),
+		WithOpener(redirectOpener(url.Values{"code": {"code"}})),
+	)
+	if _, err := m.Open(context.Background(), "test"); err != nil {
+		t.Fatal(err)
+	}
+	if active := ActiveListeners(); len(active) != 0 {
+		t.Errorf("got listeners %q after Open returned", active)
+	}
+}
@@ -0,0 +1,143 @@
+package oauthdialog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/skratchdot/open-golang/open"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// A function opening the authorization URL for the user, usually in their
+// browser. It may block, in which case ctx is done when the dialog completes
+// or is cancelled.
+type Opener func(ctx context.Context, url string) error
+
+func defaultOpener(ctx context.Context, url string) error {
+	return open.Run(url)
+}
+
+// An error returned by an opener made with FallbackOpener when all its
+// openers failed.
+type OpenerError struct {
+	// The errors of the openers, in order.
+	Errors []error
+}
+
+func (e *OpenerError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return "All openers failed: " + strings.Join(msgs, "; ")
+}
+
+// Is reports whether any of the openers' errors matches target.
+func (e *OpenerError) Is(target error) bool {
+	for _, err := range e.Errors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Get an opener trying each of openers in order until one succeeds, e.g. the
+// system browser then PrintOpener. If all fail, an *OpenerError is returned.
+func FallbackOpener(openers ...Opener) Opener {
+	return func(ctx context.Context, url string) error {
+		var errs []error
+		for _, opener := range openers {
+			err := opener(ctx, url)
+			if err == nil {
+				return nil
+			}
+			errs = append(errs, err)
+			if ctx.Err() != nil {
+				break
+			}
+		}
+		return &OpenerError{Errors: errs}
+	}
+}
+
+// Get an opener printing the URL to w for the user to open it, e.g. on a
+// machine without a browser.
+func PrintOpener(w io.Writer) Opener {
+	return func(ctx context.Context, url string) error {
+		_, err := fmt.Fprintf(w, "To sign in, open %v\n", url)
+		return err
+	}
+}
+
+// Flags asking browsers to open a URL in a new window, by lowercase
+// executable or application name.
+var newWindowFlags = map[string]string{
+	"firefox":          "-new-window",
+	"chrome":           "--new-window",
+	"google chrome":    "--new-window",
+	"google-chrome":    "--new-window",
+	"chromium":         "--new-window",
+	"chromium-browser": "--new-window",
+	"brave":            "--new-window",
+	"brave browser":    "--new-window",
+	"brave-browser":    "--new-window",
+	"msedge":           "--new-window",
+	"microsoft edge":   "--new-window",
+	"microsoft-edge":   "--new-window",
+}
+
+// Get an opener asking browser to open the URL in a new window, so that the
+// page can close itself once done, which some browsers only allow for windows
+// they didn't open as a tab.
+//
+// browser is an executable name or path, such as "firefox" or
+// "google-chrome", or an application name on macOS, such as "Google Chrome".
+// Only Firefox and Chromium-based browsers support the hint; for any other
+// browser, e.g. Safari, the URL is opened as usual. The system's default
+// browser can't be given the hint since its command line isn't known.
+func NewWindowOpener(browser string) Opener {
+	name := strings.ToLower(strings.TrimSuffix(filepath.Base(browser), ".exe"))
+	flag, ok := newWindowFlags[name]
+	if !ok {
+		return func(ctx context.Context, url string) error {
+			return open.RunWith(url, browser)
+		}
+	}
+
+	if runtime.GOOS == "darwin" {
+		return execOpener("open", "-n", "-a", browser, "--args", flag)
+	}
+	return execOpener(browser, flag)
+}
+
+// Creates the commands run by openers. Only tests may replace it, to check
+// the commands without running them.
+var execCommand = exec.Command
+
+// Get an opener running a command with the URL as last argument. The command
+// isn't waited for, since browsers may only exit once closed.
+func execOpener(name string, args ...string) Opener {
+	return execOpenerEnv(name, nil, args...)
+}
+
+// Get an opener running a command as execOpener does, with env added to its
+// environment.
+func execOpenerEnv(name string, env []string, args ...string) Opener {
+	return func(ctx context.Context, url string) error {
+		cmd := execCommand(name, append(append([]string(nil), args...), url)...)
+		if len(env) > 0 {
+			cmd.Env = append(os.Environ(), env...)
+		}
+		if err := cmd.Start(); err != nil {
+			return fmt.Errorf("Failed to run browser command %q: %w", name, err)
+		}
+		go cmd.Wait()
+		return nil
+	}
+}
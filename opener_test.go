@@ -0,0 +1,129 @@
+package oauthdialog
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/url"
+	"os"
+	"os/exec"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+var errNoBrowser = errors.New("no browser")
+
+func failingOpener(ctx context.Context, url string) error {
+	return errNoBrowser
+}
+
+func TestFallbackOpener(t *testing.T) {
+	d := New(testConfig(),
+		WithOpener(failingOpener),
+		WithFallbackOpeners(redirectOpener(url.Values{"code": {"code"}})),
+	)
+	res, err := d.OpenContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Code != "code" {
+		t.Errorf("got code %q", res.Code)
+	}
+}
+
+func TestFallbackOpenerAllFail(t *testing.T) {
+	errNoPrinter := errors.New("no printer")
+	open := FallbackOpener(failingOpener, func(ctx context.Context, url string) error {
+		return errNoPrinter
+	})
+	err := open(context.Background(), "https://provider.example/auth")
+	var openerErr *OpenerError
+	if !errors.As(err, &openerErr) || len(openerErr.Errors) != 2 {
+		t.Fatalf("got error %v, want an *OpenerError with 2 errors", err)
+	}
+	if !errors.Is(err, errNoBrowser) || !errors.Is(err, errNoPrinter) {
+		t.Errorf("got error %v, want it to match both errors", err)
+	}
+}
+
+func TestPrintOpener(t *testing.T) {
+	var out bytes.Buffer
+	if err := PrintOpener(&out)(context.Background(), "https://provider.example/auth"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "https://provider.example/auth\n") {
+		t.Errorf("got output %q", out.String())
+	}
+}
+
+// A command run by an opener.
+type execCall struct {
+	args []string
+	cmd  *exec.Cmd
+}
+
+// Replace execCommand until the test ends with a fake recording the commands
+// and running a process exiting at once instead.
+func fakeExec(t *testing.T) *[]execCall {
+	var calls []execCall
+	t.Cleanup(func() { execCommand = exec.Command })
+	execCommand = func(name string, args ...string) *exec.Cmd {
+		cmd := exec.Command(os.Args[0], "-test.run=^$")
+		calls = append(calls, execCall{args: append([]string{name}, args...), cmd: cmd})
+		return cmd
+	}
+	return &calls
+}
+
+func TestNewWindowOpener(t *testing.T) {
+	if runtime.GOOS == "darwin" {
+		t.Skip("browsers are opened with open on macOS")
+	}
+	for browser, want := range map[string][]string{
+		"firefox":                {"firefox", "-new-window", "https://provider.example/auth"},
+		"/usr/bin/google-chrome": {"/usr/bin/google-chrome", "--new-window", "https://provider.example/auth"},
+	} {
+		calls := fakeExec(t)
+		if err := NewWindowOpener(browser)(context.Background(), "https://provider.example/auth"); err != nil {
+			t.Fatal(err)
+		}
+		if len(*calls) != 1 || !reflect.DeepEqual((*calls)[0].args, want) {
+			t.Errorf("got commands %v, want %v", *calls, want)
+		}
+	}
+}
+
+func TestBrowserEnv(t *testing.T) {
+	calls := fakeExec(t)
+	d := New(testConfig(),
+		WithBrowserCommand("chromium", "--profile-directory=Work"),
+		WithBrowserEnv("BROWSER_PROFILE=work"),
+	)
+	if err := d.opener(context.Background(), "https://provider.example/auth"); err != nil {
+		t.Fatal(err)
+	}
+	if len(*calls) != 1 {
+		t.Fatalf("got %v commands", len(*calls))
+	}
+	call := (*calls)[0]
+	if want := []string{"chromium", "--profile-directory=Work", "https://provider.example/auth"}; !reflect.DeepEqual(call.args, want) {
+		t.Errorf("got command %v, want %v", call.args, want)
+	}
+	env := call.cmd.Env
+	if len(env) == 0 || env[len(env)-1] != "BROWSER_PROFILE=work" {
+		t.Errorf("got environment %v, want BROWSER_PROFILE=work", env)
+	}
+	if len(env) != len(os.Environ())+1 {
+		t.Errorf("got %v variables, want the caller's environment too", len(env))
+	}
+}
+
+func TestBrowserCommandFailure(t *testing.T) {
+	d := New(testConfig(), WithBrowserCommand("/nonexistent/browser"))
+	err := d.opener(context.Background(), "https://provider.example/auth")
+	if err == nil || !strings.Contains(err.Error(), "/nonexistent/browser") {
+		t.Errorf("got error %v, want one naming the command", err)
+	}
+}
@@ -0,0 +1,615 @@
+package oauthdialog
+
+import (
+	"context"
+	"crypto"
+	"crypto/tls"
+	"golang.org/x/oauth2"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// An option for a Dialog.
+type Option func(*Dialog)
+
+// Request additional scopes. They are merged with the config's scopes, which
+// are left untouched.
+func WithScopes(scopes ...string) Option {
+	return func(d *Dialog) {
+		d.scopes = append(d.scopes, scopes...)
+	}
+}
+
+// Merge scopes into a new slice, removing duplicates.
+func mergeScopes(lists ...[]string) []string {
+	var merged []string
+	seen := make(map[string]bool)
+	for _, l := range lists {
+		for _, s := range l {
+			if s == "" || seen[s] {
+				continue
+			}
+			seen[s] = true
+			merged = append(merged, s)
+		}
+	}
+	return merged
+}
+
+// Serve the callback on ln instead of a random loopback TCP port. The dialog
+// takes ownership of the listener and closes it when done.
+//
+// A Unix domain socket listener is accepted too, in which case the config's
+// RedirectURL is sent as-is. Real OAuth2 providers can't redirect to a Unix
+// socket, this is only useful for tests and behind a proxy.
+func WithListener(ln net.Listener) Option {
+	return func(d *Dialog) {
+		d.listener = ln
+	}
+}
+
+// Set the number of random bytes drawn for the state, before base64url
+// encoding. Defaults to 32, values below 16 make Open fail with
+// ErrStateTooShort.
+func WithStateLength(n int) Option {
+	return func(d *Dialog) {
+		d.stateLength = n
+	}
+}
+
+// Keep the local server running after a successful Open, so that it can also
+// receive the post-logout redirect. See WaitForLogout. Close must be called
+// to stop the server; opening the dialog again also stops it before starting
+// a new one.
+func WithKeepAlive() Option {
+	return func(d *Dialog) {
+		d.keepAlive = true
+	}
+}
+
+// Listen on the address of the named network interface instead of
+// 127.0.0.1. The address is resolved when the dialog is opened, preferring
+// IPv4.
+func WithInterface(name string) Option {
+	return func(d *Dialog) {
+		d.iface = name
+	}
+}
+
+// Ask the provider for a refresh token, and make Token fail with
+// ErrNoRefreshToken if none is returned.
+//
+// By default this sends access_type=offline and prompt=consent, which Google
+// requires to issue a refresh token on re-authorization. Other providers can
+// be given their own parameters through opts, e.g. Microsoft issues refresh
+// tokens when the offline_access scope is requested instead.
+func WithRefreshToken(opts ...oauth2.AuthCodeOption) Option {
+	if len(opts) == 0 {
+		opts = []oauth2.AuthCodeOption{oauth2.AccessTypeOffline, oauth2.ApprovalForce}
+	}
+	return func(d *Dialog) {
+		d.requireRefreshToken = true
+		d.authOpts = append(d.authOpts, opts...)
+	}
+}
+
+// Add opts to every authorization URL, before the options given to Open or
+// AuthCodeURL, e.g. a provider-specific parameter such as response_mode.
+func WithAuthCodeOptions(opts ...oauth2.AuthCodeOption) Option {
+	return func(d *Dialog) {
+		d.authOpts = append(d.authOpts, opts...)
+	}
+}
+
+// Capture additional parameters of the provider's redirect, made available
+// in Result.Extra.
+func WithCallbackParams(names ...string) Option {
+	return func(d *Dialog) {
+		d.callbackParams = append(d.callbackParams, names...)
+	}
+}
+
+// Set the device authorization endpoint used by DeviceFlowContext.
+func WithDeviceAuthURL(url string) Option {
+	return func(d *Dialog) {
+		d.deviceAuthURL = url
+	}
+}
+
+// Set the function presenting the device authorization to the user in
+// DeviceFlowContext, e.g. to render a QR code. By default the verification
+// URI and user code are printed to stderr.
+func WithDeviceDisplay(display DeviceDisplayFunc) Option {
+	return func(d *Dialog) {
+		d.deviceDisplay = display
+	}
+}
+
+// Serve the callback over HTTPS with a self-signed certificate generated for
+// the loopback addresses. Browsers will warn about the certificate, this is
+// meant for providers refusing plain HTTP redirect URIs.
+func WithTLS() Option {
+	return func(d *Dialog) {
+		d.useTLS = true
+	}
+}
+
+// Set the minimum TLS version of the server started by WithTLS. Defaults to
+// TLS 1.2.
+func WithTLSMinVersion(version uint16) Option {
+	return func(d *Dialog) {
+		d.tlsMinVersion = version
+	}
+}
+
+// Serve the callback over HTTPS with the given TLS config, used as-is.
+func WithTLSConfig(conf *tls.Config) Option {
+	return func(d *Dialog) {
+		d.tlsConfig = conf
+	}
+}
+
+// Serve a page asking the user to finish authorizing in their browser when
+// the callback URL is visited without a response from the provider, instead
+// of a 404. A default page is used if h is nil.
+func WithWaitingHandler(h http.HandlerFunc) Option {
+	if h == nil {
+		h = defaultWaitingHandler
+	}
+	return func(d *Dialog) {
+		d.waitingHandler = h
+	}
+}
+
+// Make Token fail with a *MissingScopesError if the provider didn't grant all
+// of these scopes. Scope lists are compared regardless of order and
+// whitespace.
+func WithRequiredScopes(scopes ...string) Option {
+	return func(d *Dialog) {
+		d.requiredScopes = append(d.requiredScopes, scopes...)
+	}
+}
+
+// Send redirectURL to the provider instead of the address of the local
+// server, which keeps listening on loopback.
+//
+// This is an escape hatch for providers requiring a registered redirect URI
+// that doesn't point to loopback: the browser must still end up on the local
+// server, through a localhost alias or a proxy, or the dialog will never
+// complete. The redirect URI is also sent when exchanging the code.
+func WithRedirectURL(redirectURL string) Option {
+	return func(d *Dialog) {
+		d.redirectOverride = redirectURL
+	}
+}
+
+// Open the authorization URL with opener instead of the system browser.
+func WithOpener(opener Opener) Option {
+	return func(d *Dialog) {
+		d.opener = opener
+	}
+}
+
+// Fall back to each of openers in order when opening the authorization URL
+// fails, e.g. with PrintOpener when no browser is available. Whatever the
+// order of the options, the opener set with WithOpener or the system browser is
+// tried first. If all fail, an *OpenerError is returned.
+func WithFallbackOpeners(openers ...Opener) Option {
+	return func(d *Dialog) {
+		d.fallbackOpeners = append(d.fallbackOpeners, openers...)
+	}
+}
+
+// Request a token for the given resources, as defined in RFC 8707. Each URI
+// is sent as a separate resource parameter of the authorization request. To
+// also send a resource when exchanging the code, pass
+// oauth2.SetAuthURLParam("resource", uri) to Exchange.
+func WithResource(uris ...string) Option {
+	return func(d *Dialog) {
+		d.resources = append(d.resources, uris...)
+	}
+}
+
+// Give up waiting for the provider's redirect after timeout, failing with
+// ErrTimeout.
+func WithTimeout(timeout time.Duration) Option {
+	return func(d *Dialog) {
+		d.timeout = timeout
+	}
+}
+
+// Give up waiting for the user after timeout once the authorization page is
+// opened, failing with ErrNoInteraction. Without an opener, the limit starts
+// when Wait is called, or when Prepare starts the flow. Unlike WithTimeout, the time spent
+// opening the page, e.g. by a blocking opener, isn't counted. There is no
+// limit by default.
+func WithInteractionTimeout(timeout time.Duration) Option {
+	return func(d *Dialog) {
+		d.interactionTimeout = timeout
+	}
+}
+
+// Serve the default page if the handler chosen for the provider's redirect,
+// such as SuccessHandler, doesn't finish after timeout. The context of its
+// request is done at that point and what it wrote is discarded, since its
+// response is buffered until it returns or flushes through http.Flusher.
+// Once flushed, the response is sent as it is written and is kept past the
+// timeout, which then only ends the context. The default is 5 seconds; zero
+// removes the limit.
+func WithHandlerTimeout(timeout time.Duration) Option {
+	return func(d *Dialog) {
+		d.handlerTimeout = timeout
+	}
+}
+
+// Give up exchanging the authorization code after timeout, failing with an
+// error matching ErrExchangeTimeout. The default is 30 seconds; zero removes
+// the limit, leaving only the deadline of the context given to Exchange.
+func WithExchangeTimeout(timeout time.Duration) Option {
+	return func(d *Dialog) {
+		d.exchangeTimeout = timeout
+	}
+}
+
+// Protect the flow with PKCE, as defined in RFC 7636. A new S256 challenge
+// is sent with each authorization request and the verifier is sent by
+// Exchange.
+//
+// Public clients, which can't keep a secret, must leave the config's
+// ClientSecret empty: the client ID is then sent in the token request body
+// and no client_secret parameter is sent at all.
+func WithPKCE() Option {
+	return func(d *Dialog) {
+		d.pkce = true
+	}
+}
+
+// Set the text of the page shown after user authorization, unless
+// SuccessHandler is replaced.
+func WithSuccessMessage(msg string) Option {
+	return func(d *Dialog) {
+		d.successMessage = msg
+	}
+}
+
+// Set the text of the page shown when the provider returns an error, unless
+// ErrorHandler is replaced.
+func WithErrorMessage(msg string) Option {
+	return func(d *Dialog) {
+		d.errorMessage = msg
+	}
+}
+
+// Set the language, e.g. "ar", and text direction, "ltr" or "rtl", of the
+// pages shown by the default handlers. Either can be left empty.
+func WithLanguage(lang, dir string) Option {
+	return func(d *Dialog) {
+		d.page = page{lang: lang, dir: dir}
+	}
+}
+
+// Rewrite the authorization URL before it is opened, e.g. to add a parameter
+// required by a gateway. If transform fails, so does Open.
+func WithURLTransform(transform func(*url.URL) (*url.URL, error)) Option {
+	return func(d *Dialog) {
+		d.urlTransform = transform
+	}
+}
+
+// Log diagnostics, such as unexpected callbacks, to logger. Secrets are
+// redacted. Nothing is logged by default.
+func WithLogger(logger *log.Logger) Option {
+	return func(d *Dialog) {
+		d.logger = logger
+	}
+}
+
+// Refuse to open an authorization URL that isn't HTTPS, failing with
+// ErrInsecureAuthURL, so that the client ID and PKCE challenge never leak
+// over plain HTTP. Plain HTTP is still allowed for insecureHosts, e.g.
+// "localhost:8080" for a local development server.
+func WithRequireHTTPS(insecureHosts ...string) Option {
+	return func(d *Dialog) {
+		d.requireHTTPS = true
+		d.insecureHosts = append(d.insecureHosts, insecureHosts...)
+	}
+}
+
+// Set how long a callback already being delivered when the dialog is
+// cancelled or times out may still complete the flow, in which case its
+// result is returned instead of the error. Defaults to 300ms, zero disables
+// it.
+func WithShutdownGrace(grace time.Duration) Option {
+	return func(d *Dialog) {
+		d.shutdownGrace = grace
+	}
+}
+
+// Use a JWT signed with key using HS256 as state, carrying claims, e.g. the
+// URL to return to, and expiring after maxAge, or 10 minutes if zero. The
+// verified claims are available in Result.StateClaims, and ParseSignedState
+// can verify the state of a flow started by another process.
+//
+// The JWT still embeds a random CSRF token as its jti claim and must match
+// the state sent by the flow. It is an alternative to the plain random
+// state, it doesn't replace PKCE or an OpenID Connect nonce.
+func WithSignedState(key []byte, claims map[string]interface{}, maxAge time.Duration) Option {
+	return func(d *Dialog) {
+		d.stateKey = key
+		d.stateClaims = claims
+		d.stateMaxAge = maxAge
+	}
+}
+
+// Push the authorization request to endpoint before opening the dialog, as
+// defined in RFC 9126, so that only the client ID and the returned
+// request_uri appear in the browser. Failures are reported as a *PARError.
+func WithPAR(endpoint string) Option {
+	return func(d *Dialog) {
+		d.parURL = endpoint
+	}
+}
+
+// Respond to the callback with a JSON body and the given status code instead
+// of an HTML page: {"status":"ok"} on success and {"status":"error"} when the
+// provider returned an error.
+func WithJSONResponse(status int) Option {
+	return func(d *Dialog) {
+		d.jsonStatus = status
+	}
+}
+
+// Open the authorization URL by running the given command, with the URL
+// appended to args, e.g. WithBrowserCommand("firefox", "--private-window"),
+// instead of the system's default browser. The command isn't looked up
+// until the dialog is opened, where failing to run it is returned as an
+// error. Variables set with WithBrowserEnv are added to its environment.
+func WithBrowserCommand(name string, args ...string) Option {
+	return func(d *Dialog) {
+		d.opener = func(ctx context.Context, url string) error {
+			return execOpenerEnv(name, d.browserEnv, args...)(ctx, url)
+		}
+	}
+}
+
+// Add env, as "KEY=value" strings, to the environment of the command set
+// with WithBrowserCommand, e.g. to select the browser profile signed in to
+// the identity provider. Profiles are usually chosen with flags though, such
+// as "--profile-directory=Profile 1" for Chromium-based browsers or
+// "-P work" for Firefox.
+func WithBrowserEnv(env ...string) Option {
+	return func(d *Dialog) {
+		d.browserEnv = append(d.browserEnv, env...)
+	}
+}
+
+// Accept callbacks whatever their Host header. By default, when the redirect
+// URI is on a loopback address, requests addressed to any other host, as in
+// DNS rebinding attacks, are rejected.
+func WithAnyHost() Option {
+	return func(d *Dialog) {
+		d.anyHost = true
+	}
+}
+
+// Mark provider error codes as expected, such as interaction_required after
+// an attempt with prompt=none, so that callers can tell them from failures:
+// the *OAuthError returned for them also matches ErrSoftError.
+func WithSoftErrors(codes ...string) Option {
+	return func(d *Dialog) {
+		if d.softErrors == nil {
+			d.softErrors = make(map[string]bool, len(codes))
+		}
+		for _, code := range codes {
+			d.softErrors[code] = true
+		}
+	}
+}
+
+// Reject responses whose iss parameter, defined in RFC 9207, isn't issuer,
+// failing with an *IssuerError, to prevent mix-up attacks when using several
+// providers. If required is false, responses without iss are accepted, for
+// providers which don't send it. Responses are never checked by default, the
+// iss parameter is only available as Result.Issuer.
+func WithIssuer(issuer string, required bool) Option {
+	return func(d *Dialog) {
+		d.issuer = issuer
+		d.issuerRequired = required
+	}
+}
+
+// Only accept callbacks on the exact path of the redirect URI. By default, a
+// trailing slash added or dropped by the provider or the browser is ignored,
+// e.g. a redirect URI with the path /callback also accepts /callback/.
+func WithStrictCallbackPath() Option {
+	return func(d *Dialog) {
+		d.strictPath = true
+	}
+}
+
+// Persist the state, PKCE verifier and redirect URI of each flow to store
+// while it is in progress, so that it can be completed with Resume and
+// Exchange by another Dialog, e.g. with a MemoryStateStore shared by the
+// dialogs of a process. Without a store they are only kept in the Dialog's
+// memory.
+func WithStateStore(store StateStore) Option {
+	return func(d *Dialog) {
+		d.stateStore = store
+	}
+}
+
+// Add params to the token request made by Token and Exchange, e.g. audience
+// or client_assertion. Only the first value of each parameter is sent. A
+// parameter named like a standard one, such as redirect_uri, replaces it,
+// but the PKCE verifier and the options given to Exchange take precedence.
+func WithEndpointParams(params url.Values) Option {
+	return func(d *Dialog) {
+		if d.endpointParams == nil {
+			d.endpointParams = url.Values{}
+		}
+		for name, values := range params {
+			d.endpointParams[name] = append([]string(nil), values...)
+		}
+	}
+}
+
+// Ask for the given response_type, e.g. "code id_token" for the OpenID
+// Connect hybrid flow, instead of "code". The callback then fails with
+// ErrIncompleteCallback unless all the requested values are returned. Since
+// tokens are returned in the fragment by default, response_mode is set to
+// form_post when the response type includes a token, unless given to Open or
+// WithFragmentCapture is used.
+// An unsupported combination is reported by Open as ErrInvalidResponseType.
+func WithResponseType(values ...string) Option {
+	return func(d *Dialog) {
+		d.responseType = append([]string(nil), values...)
+	}
+}
+
+// Send userAgent as the User-Agent of the token request made by Token and
+// Exchange, instead of Go's default. Requests made by the browser aren't
+// affected.
+func WithUserAgent(userAgent string) Option {
+	return func(d *Dialog) {
+		d.userAgent = userAgent
+	}
+}
+
+// Capture responses returned in the fragment of the redirect URI, as in the
+// implicit flow, which the server can't see: the callback serves a page
+// posting the fragment back to the server. It requires JavaScript in the
+// browser. Results captured this way have the DeliveryFragment mode.
+func WithFragmentCapture() Option {
+	return func(d *Dialog) {
+		d.fragment = true
+	}
+}
+
+// Set the token revocation endpoint used by Revoke.
+func WithRevocationURL(endpoint string) Option {
+	return func(d *Dialog) {
+		d.revocationURL = endpoint
+	}
+}
+
+// Require the request posting a response captured with WithFragmentCapture
+// to carry a secret of the flow. The secret is only embedded in the first
+// bridge page served, which the browser loads when the provider redirects,
+// so that another local process replaying a sniffed callback URL, e.g. from
+// the browser history or the server logs, is rejected with a 403 once the
+// browser loaded it. It doesn't help against a process able to read the
+// browser's memory or to win the race against the provider's redirect.
+func WithFlowSecret() Option {
+	return func(d *Dialog) {
+		d.flowSecret = true
+	}
+}
+
+// Log the errors of the local server, such as malformed requests or TLS
+// handshake errors, to l. They are discarded by default.
+func WithServerErrorLog(l *log.Logger) Option {
+	return func(d *Dialog) {
+		d.serverErrorLog = l
+	}
+}
+
+// Keep accepting the state of a flow abandoned for a new one, e.g. when a
+// login is retried while the first authorization page is still open, for
+// window after it was abandoned. The callback then completes the new flow,
+// and Exchange uses the PKCE verifier of the flow the state belongs to.
+// Completed flows are never accepted again.
+func WithStateGrace(window time.Duration) Option {
+	return func(d *Dialog) {
+		d.stateGrace = window
+	}
+}
+
+// Add a collapsed section to the default success and error pages with
+// non-sensitive details for support, such as the package version, the port,
+// the delivery mode and the time the user took, so that screenshots of the
+// page carry them. The code and tokens are never included.
+func WithDiagnostics() Option {
+	return func(d *Dialog) {
+		d.diagnostics = true
+	}
+}
+
+// Authenticate the client with a JWT signed by key, as the private_key_jwt
+// method of OpenID Connect and FAPI requires, instead of the client secret.
+// alg is RS256, PS256 or ES256, and kid, if not empty, identifies the key to
+// the provider. The assertion is sent with the token request, the pushed
+// authorization request and the revocation request.
+func WithPrivateKeyJWT(key crypto.Signer, alg, kid string) Option {
+	return func(d *Dialog) {
+		d.clientKey = &clientKey{signer: key, alg: alg, kid: kid}
+	}
+}
+
+// Restart the local server up to attempts times if it stops unexpectedly
+// while waiting, e.g. because the OS tore down its listener. Each restart
+// listens on a new random port and opens the authorization URL again, with
+// the new redirect URI and a new state, so the user has to authorize again.
+// Servers on a fixed address, set with WithListener or WithRedirectURL,
+// aren't restarted.
+func WithRebind(attempts int) Option {
+	return func(d *Dialog) {
+		d.rebindAttempts = attempts
+	}
+}
+
+// Compress the success and error pages with gzip when the browser accepts
+// it, e.g. for large custom pages with inline images. Handlers can still
+// flush the start of a page early through http.Flusher, which commits it
+// as described in WithHandlerTimeout.
+func WithGzip() Option {
+	return func(d *Dialog) {
+		d.gzip = true
+	}
+}
+
+// Make the user log in again even if they have a session with the provider,
+// by adding login to the prompt parameter, or not. Combined with the
+// prompt=consent sent by WithRefreshToken, prompt=consent login is sent.
+func WithForceLogin(force bool) Option {
+	return func(d *Dialog) {
+		d.forceLogin = force
+	}
+}
+
+// Try to authorize without user interaction first, reusing the provider's
+// session, by sending prompt=none instead of any other prompt, e.g. the
+// prompt=consent sent by WithRefreshToken. If the provider answers that the
+// user must interact, e.g. with login_required, the dialog is opened again
+// without prompt=none.
+func WithSilent() Option {
+	return func(d *Dialog) {
+		d.silent = true
+	}
+}
+
+// Check that the authorization endpoint is reachable before opening the
+// browser, with a HEAD request made with the client used for Exchange, so
+// that Open fails right away with a *PreflightError instead of showing an
+// error page. The request is given timeout, or 3 seconds if zero. A status
+// below 500 counts as reachable, since endpoints usually reject requests
+// without the authorization parameters.
+func WithPreflight(timeout time.Duration) Option {
+	if timeout <= 0 {
+		timeout = defaultPreflightTimeout
+	}
+	return func(d *Dialog) {
+		d.preflightTimeout = timeout
+	}
+}
+
+// Wrap the handler receiving the provider's redirect with mw, e.g. for
+// logging, panic recovery or request IDs, both on the local server and in
+// CallbackHandler. The first middleware given is the outermost.
+func WithMiddleware(mw ...func(http.Handler) http.Handler) Option {
+	return func(d *Dialog) {
+		d.middleware = append(d.middleware, mw...)
+	}
+}
@@ -0,0 +1,147 @@
+package oauthdialog
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+// Get the query of the authorization URL of a new flow of d.
+func authQuery(t *testing.T, d *Dialog) url.Values {
+	t.Helper()
+	authURL, err := d.AuthCodeURL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Query()
+}
+
+func TestWithScopes(t *testing.T) {
+	conf := testConfig()
+	conf.Scopes = []string{"openid", "email"}
+	d := New(conf, WithScopes("email", "profile"), WithScopes("openid", "offline_access"))
+
+	scopes := strings.Fields(authQuery(t, d).Get("scope"))
+	if want := []string{"openid", "email", "profile", "offline_access"}; !reflect.DeepEqual(scopes, want) {
+		t.Errorf("got scopes %v, want %v", scopes, want)
+	}
+	if want := []string{"openid", "email"}; !reflect.DeepEqual(conf.Scopes, want) {
+		t.Errorf("config scopes changed to %v", conf.Scopes)
+	}
+}
+
+func TestWithAuthCodeOptions(t *testing.T) {
+	d := New(testConfig(), WithAuthCodeOptions(
+		oauth2.SetAuthURLParam("response_mode", "form_post"),
+		oauth2.SetAuthURLParam("domain_hint", "example.com"),
+	))
+	if q := authQuery(t, d); q.Get("response_mode") != "form_post" || q.Get("domain_hint") != "example.com" {
+		t.Errorf("got query %v, want the option parameters", q)
+	}
+
+	authURL, err := d.AuthCodeURL(oauth2.SetAuthURLParam("domain_hint", "other.example"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(authURL)
+	if q := u.Query(); q.Get("response_mode") != "form_post" || q.Get("domain_hint") != "other.example" {
+		t.Errorf("got query %v, want the AuthCodeURL option to take precedence", q)
+	}
+}
+
+func TestWithURLTransform(t *testing.T) {
+	var opened string
+	redirect := redirectOpener(url.Values{"code": {"code"}})
+	d := New(testConfig(),
+		WithURLTransform(func(u *url.URL) (*url.URL, error) {
+			q := u.Query()
+			q.Set("tracking", "yes")
+			u.RawQuery = q.Encode()
+			return u, nil
+		}),
+		WithOpener(func(ctx context.Context, authURL string) error {
+			opened = authURL
+			return redirect(ctx, authURL)
+		}),
+	)
+	if _, err := d.OpenContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(opened)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q := u.Query(); q.Get("tracking") != "yes" || q.Get("state") == "" {
+		t.Errorf("opened %q, want the transformed URL", opened)
+	}
+
+	errTransform := errors.New("transform failed")
+	called := false
+	d = New(testConfig(),
+		WithURLTransform(func(u *url.URL) (*url.URL, error) {
+			return nil, errTransform
+		}),
+		WithOpener(func(ctx context.Context, authURL string) error {
+			called = true
+			return nil
+		}),
+	)
+	if _, err := d.OpenContext(context.Background()); !errors.Is(err, errTransform) {
+		t.Errorf("got error %v, want the transform's", err)
+	}
+	if called {
+		t.Error("opener called after the transform failed")
+	}
+}
+
+// Get a middleware setting a header, appending name to its value.
+func headerMiddleware(name string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("X-Middleware", name)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func TestWithMiddleware(t *testing.T) {
+	d := New(testConfig(), WithMiddleware(headerMiddleware("outer"), headerMiddleware("inner")))
+	rec := httptest.NewRecorder()
+	d.CallbackHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, callbackURL(t, d, url.Values{"code": {"code"}}), nil))
+	if got := rec.Header().Values("X-Middleware"); !reflect.DeepEqual(got, []string{"outer", "inner"}) {
+		t.Errorf("got headers %q, want outer then inner", got)
+	}
+	if _, err := d.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The local server uses them too
+	var header http.Header
+	d = New(testConfig(), WithMiddleware(headerMiddleware("server")), WithOpener(func(ctx context.Context, authURL string) error {
+		u, _ := url.Parse(authURL)
+		resp, err := http.Get(u.Query().Get("redirect_uri") + "/?state=other")
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		header = resp.Header
+		return redirectOpener(url.Values{"code": {"code"}})(ctx, authURL)
+	}))
+	if _, err := d.OpenContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := header.Get("X-Middleware"); got != "server" {
+		t.Errorf("got header %q from the local server", got)
+	}
+}
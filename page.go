@@ -0,0 +1,93 @@
+package oauthdialog
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"time"
+)
+
+// Path of this module, to find its version in the build info.
+const modulePath = "github.com/badarsebard/go-oauthdialog"
+
+const (
+	defaultSuccessMessage = "You can close this window."
+	defaultErrorMessage   = "Authorization failed. You can close this window."
+	defaultWaitingMessage = "Waiting for you to finish authorizing in your browser."
+	completedMessage      = "This authorization has already been completed. You can close this window."
+)
+
+var (
+	defaultSuccessHandler = page{}.handler(defaultSuccessMessage)
+	defaultWaitingHandler = page{}.handler(defaultWaitingMessage)
+	completedHandler      = page{}.handler(completedMessage)
+)
+
+// Settings of the pages served by the default handlers.
+type page struct {
+	lang string
+	dir  string
+}
+
+// Get a handler serving a page with the given message.
+func (p page) handler(msg string) http.HandlerFunc {
+	b := []byte("<!DOCTYPE html>\n<html")
+	if p.lang != "" {
+		b = append(b, ` lang="`+html.EscapeString(p.lang)+`"`...)
+	}
+	if p.dir != "" {
+		b = append(b, ` dir="`+html.EscapeString(p.dir)+`"`...)
+	}
+	b = append(b, "><meta charset=\"utf-8\"><p>"+html.EscapeString(msg)+"</p>"...)
+
+	return func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(b)
+		if diag, ok := req.Context().Value(diagnosticsContextKey{}).(string); ok {
+			w.Write([]byte("<details><summary>Details</summary><pre>" + html.EscapeString(diag) + "</pre></details>"))
+		}
+		w.Write([]byte("</html>\n"))
+	}
+}
+
+// JSONHandler returns a handler responding with the given status code and v
+// encoded as JSON, for callers such as browser extensions that expect a
+// machine-readable callback response. It can be used as SuccessHandler or
+// ErrorHandler; the result is delivered to the dialog before it runs.
+func JSONHandler(status int, v interface{}) http.HandlerFunc {
+	b, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	b = append(b, '\n')
+
+	return func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write(b)
+	}
+}
+
+type diagnosticsContextKey struct{}
+
+// Get non-sensitive details of how the callback was received, added to the
+// default pages by WithDiagnostics.
+func (f *flow) diagnostics(req *http.Request, res *handlerResponse) string {
+	port := "unknown"
+	if u, err := url.Parse(f.redirectURL); err == nil && u.Port() != "" {
+		port = u.Port()
+	}
+	version := "unknown"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range append([]*debug.Module{&info.Main}, info.Deps...) {
+			if dep.Path == modulePath {
+				version = dep.Version
+			}
+		}
+	}
+	return fmt.Sprintf("oauthdialog %v, port %v, mode %v, %v elapsed",
+		version, port, res.Mode, time.Since(f.started).Round(time.Millisecond))
+}
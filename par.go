@@ -0,0 +1,129 @@
+package oauthdialog
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/oauth2"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// An error returned when pushing the authorization request to the endpoint
+// set with WithPAR fails.
+type PARError struct {
+	// The HTTP status code returned by the endpoint, or zero if no response
+	// was received.
+	StatusCode int
+
+	err error
+}
+
+func (e *PARError) Error() string {
+	if e.StatusCode == 0 {
+		return fmt.Sprintf("Pushed authorization request failed: %v", e.err)
+	}
+	return fmt.Sprintf("Pushed authorization request failed with status %v: %v", e.StatusCode, e.err)
+}
+
+// Unwrap returns the underlying error, an *OAuthError if the endpoint
+// returned one.
+func (e *PARError) Unwrap() error {
+	return e.err
+}
+
+// Push the parameters of the authorization URL to the PAR endpoint, as
+// defined in RFC 9126, and get the authorization URL referencing them.
+func (d *Dialog) pushAuthRequest(ctx context.Context, authURL string) (string, error) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		return "", err
+	}
+	params := u.Query()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.parURL, nil)
+	if err != nil {
+		return "", err
+	}
+	if err := d.authenticateClient(req, params); err != nil {
+		return "", err
+	}
+	body := params.Encode()
+	req.Body = io.NopCloser(strings.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := httpClient(ctx).Do(req)
+	if err != nil {
+		return "", &PARError{err: err}
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", &PARError{StatusCode: resp.StatusCode, err: err}
+	}
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		var errResp struct {
+			Error            string `json:"error"`
+			ErrorDescription string `json:"error_description"`
+			ErrorURI         string `json:"error_uri"`
+		}
+		err := fmt.Errorf("Unexpected response: %v", redactBody(b))
+		if json.Unmarshal(b, &errResp) == nil && errResp.Error != "" {
+			err = &OAuthError{
+				Code:        errResp.Error,
+				Description: errResp.ErrorDescription,
+				URI:         errResp.ErrorURI,
+			}
+		}
+		return "", &PARError{StatusCode: resp.StatusCode, err: err}
+	}
+
+	var parResp struct {
+		RequestURI string `json:"request_uri"`
+	}
+	if err := json.Unmarshal(b, &parResp); err != nil {
+		return "", &PARError{StatusCode: resp.StatusCode, err: err}
+	}
+	if parResp.RequestURI == "" {
+		return "", &PARError{StatusCode: resp.StatusCode, err: errors.New("Missing request_uri")}
+	}
+
+	u.RawQuery = url.Values{
+		"client_id":   {d.config.ClientID},
+		"request_uri": {parResp.RequestURI},
+	}.Encode()
+	return u.String(), nil
+}
+
+// Authenticate the client on a request to an endpoint other than the token
+// endpoint, with the key set with WithPrivateKeyJWT or following the config's
+// AuthStyle.
+func (d *Dialog) authenticateClient(req *http.Request, params url.Values) error {
+	conf := d.config
+	if d.clientKey != nil {
+		assertion, err := d.clientAssertion(conf.Endpoint.TokenURL)
+		if err != nil {
+			return err
+		}
+		params.Set("client_id", conf.ClientID)
+		params.Set("client_assertion_type", clientAssertionType)
+		params.Set("client_assertion", assertion)
+		return nil
+	}
+	if conf.ClientSecret != "" && conf.Endpoint.AuthStyle == oauth2.AuthStyleInHeader {
+		req.SetBasicAuth(url.QueryEscape(conf.ClientID), url.QueryEscape(conf.ClientSecret))
+		return nil
+	}
+	params.Set("client_id", conf.ClientID)
+	if conf.ClientSecret != "" {
+		params.Set("client_secret", conf.ClientSecret)
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package oauthdialog
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// ErrNoCode is returned when the user pastes nothing, see WithPaste.
+var ErrNoCode = errors.New("No code entered")
+
+// Ask the user to open the authorization URL themselves and paste back the
+// URL they were redirected to, or the code displayed by the provider, e.g. on
+// hosts without a browser. The local server keeps running, so the flow still
+// completes if the browser can reach it.
+//
+// Reading from in is bounded by the context and timeout of the dialog, like
+// the browser flow: ErrTimeout is returned and a line typed meanwhile is
+// discarded.
+func WithPaste(in io.Reader, out io.Writer) Option {
+	return func(d *Dialog) {
+		d.opener = d.pasteOpener(in, out)
+	}
+}
+
+func (d *Dialog) pasteOpener(in io.Reader, out io.Writer) Opener {
+	return func(ctx context.Context, url string) error {
+		fmt.Fprintf(out, "Open this URL in a browser:\n\n%v\n\nThen paste the URL you were redirected to, or the code: ", url)
+
+		lines := make(chan string, 1)
+		errs := make(chan error, 1)
+		go func() {
+			line, err := bufio.NewReader(in).ReadString('\n')
+			if err != nil && line == "" {
+				errs <- err
+				return
+			}
+			lines <- line
+		}()
+
+		select {
+		case line := <-lines:
+			f := d.currentFlow()
+			res, err := d.parsePasted(f, line)
+			if err != nil {
+				return err
+			}
+			d.deliver(f, res)
+			return nil
+		case err := <-errs:
+			if errors.Is(err, io.EOF) {
+				return ErrNoCode
+			}
+			return err
+		case <-ctx.Done():
+			// The dialog is done, drop whatever is read from now on
+			return nil
+		}
+	}
+}
+
+// Parse a redirect URL or a bare code pasted by the user.
+func (d *Dialog) parsePasted(f *flow, s string) (*handlerResponse, error) {
+	res, bare, err := parseCallbackURL(s, d.callbackParams)
+	if err != nil {
+		return nil, err
+	}
+
+	// A bare code is copied by the user from the provider's page: there is no
+	// state to check
+	if bare && f != nil {
+		res.State = f.state
+	}
+	return res, nil
+}
+
+// Parse a redirect URL, with parameters in the query or the fragment, or a
+// bare code, which has no state.
+func parseCallbackURL(s string, extra []string) (res *handlerResponse, bare bool, err error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, false, ErrNoCode
+	}
+
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme == "" {
+		return &handlerResponse{Code: s, Mode: DeliveryQuery}, true, nil
+	}
+
+	params := u.Query()
+	mode := DeliveryQuery
+	if u.Fragment != "" {
+		fragment, err := url.ParseQuery(u.Fragment)
+		if err != nil {
+			return nil, false, err
+		}
+		for k, v := range fragment {
+			params[k] = v
+		}
+		mode = DeliveryFragment
+	}
+
+	res = parseParams(params, extra)
+	res.Mode = mode
+	return res, false, nil
+}
+
+// Parse the URL the provider redirected to, with parameters in the query or
+// the fragment, or a bare authorization code, e.g. pasted by the user. An
+// error returned by the provider is returned as an *OAuthError. The state
+// isn't checked.
+func ParseCallbackURL(raw string) (*Result, error) {
+	res, _, err := parseCallbackURL(raw, nil)
+	if err != nil {
+		return nil, err
+	}
+	if res.Error != "" {
+		return nil, res.oauthError()
+	}
+	return res.result(), nil
+}
@@ -0,0 +1,47 @@
+package oauthdialog
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseCallbackURL(t *testing.T) {
+	tests := []struct {
+		raw   string
+		code  string
+		state string
+		mode  DeliveryMode
+	}{
+		{"http://127.0.0.1:8080/?code=abc&state=xyz", "abc", "xyz", DeliveryQuery},
+		{"  http://127.0.0.1/callback?state=xyz&code=abc\n", "abc", "xyz", DeliveryQuery},
+		{"myapp://callback#code=abc&state=xyz", "abc", "xyz", DeliveryFragment},
+		{"https://app.example/cb?state=xyz#code=abc", "abc", "xyz", DeliveryFragment},
+		{"abc", "abc", "", DeliveryQuery},
+		{" 4/0AX4XfWh-abc \n", "4/0AX4XfWh-abc", "", DeliveryQuery},
+	}
+	for _, test := range tests {
+		res, err := ParseCallbackURL(test.raw)
+		if err != nil {
+			t.Errorf("ParseCallbackURL(%q) failed: %v", test.raw, err)
+			continue
+		}
+		if res.Code != test.code || res.State != test.state || res.Mode != test.mode {
+			t.Errorf("ParseCallbackURL(%q) = %+v", test.raw, res)
+		}
+	}
+}
+
+func TestParseCallbackURLErrors(t *testing.T) {
+	if _, err := ParseCallbackURL(" "); err != ErrNoCode {
+		t.Errorf("got error %v for an empty input, want ErrNoCode", err)
+	}
+
+	_, err := ParseCallbackURL("http://127.0.0.1/?error=access_denied&error_description=no&state=xyz")
+	var oauthErr *OAuthError
+	if !errors.As(err, &oauthErr) || !errors.Is(err, ErrAccessDenied) {
+		t.Fatalf("got error %v, want an *OAuthError", err)
+	}
+	if oauthErr.Description != "no" {
+		t.Errorf("got description %q", oauthErr.Description)
+	}
+}
@@ -0,0 +1,28 @@
+package oauthdialog
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"golang.org/x/oauth2"
+)
+
+// Number of random bytes of the PKCE code verifier, giving a 43 characters
+// verifier as recommended by RFC 7636 section 4.1.
+const verifierLength = 32
+
+// Generate a PKCE code verifier and get the authorization request parameters
+// carrying its S256 challenge.
+func newPKCE() (verifier string, opts []oauth2.AuthCodeOption, err error) {
+	verifier, err = randomString(verifierLength)
+	if err != nil {
+		return "", nil, err
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+	opts = []oauth2.AuthCodeOption{
+		oauth2.SetAuthURLParam("code_challenge", challenge),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+	}
+	return verifier, opts, nil
+}
@@ -0,0 +1,59 @@
+package oauthdialog
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Default time given to the preflight request.
+const defaultPreflightTimeout = 3 * time.Second
+
+// An error returned by Open when the authorization endpoint isn't reachable,
+// with WithPreflight.
+type PreflightError struct {
+	URL string
+	// The HTTP status code returned by the endpoint, or zero if it couldn't be
+	// reached at all, e.g. because of a DNS or connection failure.
+	StatusCode int
+
+	err error
+}
+
+func (e *PreflightError) Error() string {
+	if e.StatusCode == 0 {
+		return fmt.Sprintf("Authorization endpoint %v unreachable: %v", e.URL, e.err)
+	}
+	return fmt.Sprintf("Authorization endpoint %v failing with status %v", e.URL, e.StatusCode)
+}
+
+// Unwrap returns the network error, if any.
+func (e *PreflightError) Unwrap() error {
+	return e.err
+}
+
+// Check that the authorization endpoint answers, with a HEAD request made
+// with the client used for Exchange.
+func (d *Dialog) preflight(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, d.preflightTimeout)
+	defer cancel()
+
+	endpoint := d.config.Endpoint.AuthURL
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, endpoint, nil)
+	if err != nil {
+		return &PreflightError{URL: endpoint, err: err}
+	}
+	resp, err := httpClient(d.userAgentContext(ctx)).Do(req)
+	if err != nil {
+		return &PreflightError{URL: endpoint, err: err}
+	}
+	resp.Body.Close()
+
+	// Client errors are expected without the authorization parameters, only
+	// server errors tell that the endpoint is down
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return &PreflightError{URL: endpoint, StatusCode: resp.StatusCode}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package oauthdialog
+
+import (
+	"context"
+	"golang.org/x/oauth2"
+	"time"
+)
+
+// A flow whose local server is listening and whose authorization URL is
+// known, but which isn't opened yet, e.g. to show the URL behind a sign in
+// button. Complete must be called to wait for the result and stop the
+// server.
+type PreparedFlow struct {
+	// The authorization URL.
+	URL string
+	// The redirect URI sent to the provider.
+	RedirectURL string
+
+	d          *Dialog
+	served     <-chan error
+	opened     chan error
+	openCtx    context.Context
+	cancelOpen context.CancelFunc
+	// Measures the interaction timeout until the URL is opened, since it
+	// may be shown to the user from the start
+	interaction *time.Timer
+}
+
+// Start the local server and a new flow without opening the authorization
+// URL. Any flow in progress is abandoned. The interaction timeout set by
+// WithInteractionTimeout starts now, and again once Open has opened the URL.
+func (d *Dialog) Prepare(opts ...oauth2.AuthCodeOption) (*PreparedFlow, error) {
+	authURL, served, err := d.prepare(context.Background(), opts...)
+	if err != nil {
+		return nil, err
+	}
+	openCtx, cancelOpen := context.WithCancel(context.Background())
+	var interaction *time.Timer
+	if d.interactionTimeout > 0 {
+		interaction = newTimer(d.interactionTimeout)
+	}
+	return &PreparedFlow{
+		URL:         authURL,
+		RedirectURL: d.RedirectURL(),
+		d:           d,
+		served:      served,
+		opened:      make(chan error, 1),
+		openCtx:     openCtx,
+		cancelOpen:  cancelOpen,
+		interaction: interaction,
+	}, nil
+}
+
+// Open the authorization URL with the dialog's opener, without waiting for
+// it. A failure to open it is returned by Complete.
+func (p *PreparedFlow) Open() {
+	// The time spent opening the URL isn't counted
+	if p.interaction != nil {
+		p.interaction.Stop()
+	}
+	go func() {
+		err := p.d.opener(p.openCtx, p.URL)
+		select {
+		case p.opened <- err:
+		case <-p.openCtx.Done():
+		}
+	}()
+}
+
+// Wait for the result of the flow until ctx is done, as OpenContext does
+// once the URL is opened.
+func (p *PreparedFlow) Complete(ctx context.Context) (res *Result, err error) {
+	defer p.cancelOpen()
+	defer func() {
+		if err != nil {
+			p.d.clearSecrets()
+		}
+		if !p.d.keepAlive || err != nil {
+			p.d.Close()
+		}
+	}()
+	return p.d.wait(ctx, p.opened, p.served, p.interaction)
+}
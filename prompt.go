@@ -0,0 +1,39 @@
+package oauthdialog
+
+import (
+	"errors"
+	"strings"
+)
+
+// OpenID Connect errors defined in OpenID Connect Core section 3.1.2.6,
+// returned when prompt=none is sent but the user must interact.
+var (
+	ErrInteractionRequired      = errors.New("Interaction required")
+	ErrLoginRequired            = errors.New("Login required")
+	ErrAccountSelectionRequired = errors.New("Account selection required")
+	ErrConsentRequired          = errors.New("Consent required")
+)
+
+// Check whether err means a silent authorization needs the user.
+func interactionRequired(err error) bool {
+	return errors.Is(err, ErrInteractionRequired) || errors.Is(err, ErrLoginRequired) ||
+		errors.Is(err, ErrAccountSelectionRequired) || errors.Is(err, ErrConsentRequired)
+}
+
+// Get the prompt parameter to send, given the one set by the options, e.g.
+// prompt=consent by WithRefreshToken.
+func (d *Dialog) prompt(current string) string {
+	if d.silent && !d.interactive {
+		// none can't be combined with any other value
+		return "none"
+	}
+	if !d.forceLogin {
+		return current
+	}
+	for _, v := range strings.Fields(current) {
+		if v == "login" {
+			return current
+		}
+	}
+	return strings.TrimSpace(current + " login")
+}
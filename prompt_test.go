@@ -0,0 +1,56 @@
+package oauthdialog
+
+import (
+	"context"
+	"net/url"
+	"testing"
+)
+
+func TestPrompt(t *testing.T) {
+	tests := []struct {
+		opts []Option
+		want string
+	}{
+		{nil, ""},
+		{[]Option{WithForceLogin(false)}, ""},
+		{[]Option{WithForceLogin(true)}, "login"},
+		{[]Option{WithRefreshToken(), WithForceLogin(true)}, "consent login"},
+		{[]Option{WithRefreshToken(), WithForceLogin(false)}, "consent"},
+		{[]Option{WithSilent()}, "none"},
+		{[]Option{WithRefreshToken(), WithSilent()}, "none"},
+		{[]Option{WithForceLogin(true), WithSilent()}, "none"},
+	}
+	for i, test := range tests {
+		if got := authQuery(t, New(testConfig(), test.opts...)).Get("prompt"); got != test.want {
+			t.Errorf("case %v: got prompt %q, want %q", i, got, test.want)
+		}
+	}
+}
+
+func TestSilentFallback(t *testing.T) {
+	var prompts []string
+	d := New(testConfig(), WithSilent(), WithForceLogin(true), WithOpener(func(ctx context.Context, authURL string) error {
+		u, _ := url.Parse(authURL)
+		prompt := u.Query().Get("prompt")
+		prompts = append(prompts, prompt)
+		if prompt == "none" {
+			return redirectOpener(url.Values{"error": {"login_required"}})(ctx, authURL)
+		}
+		return redirectOpener(url.Values{"code": {"code"}})(ctx, authURL)
+	}))
+	res, err := d.OpenContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Code != "code" {
+		t.Errorf("got code %q", res.Code)
+	}
+	if len(prompts) != 2 || prompts[0] != "none" || prompts[1] != "login" {
+		t.Errorf("got prompts %q, want none then login", prompts)
+	}
+
+	// The next flow is silent again
+	if got := authQuery(t, d).Get("prompt"); got != "none" {
+		t.Errorf("got prompt %q after the fallback, want none", got)
+	}
+}
@@ -0,0 +1,37 @@
+package oauthdialog
+
+// The protections applied to the authorization request of a flow, as sent to
+// the provider.
+type Protections struct {
+	// The PKCE code_challenge_method, e.g. "S256", or an empty string if PKCE
+	// isn't used.
+	PKCEMethod string
+	// Whether a nonce was sent, e.g. with oauth2.SetAuthURLParam.
+	Nonce bool
+	// The state, and the number of random bytes it was drawn from, as set
+	// with WithStateLength.
+	State       string
+	StateLength int
+}
+
+// Whether the flow is protected with PKCE.
+func (p Protections) PKCE() bool {
+	return p.PKCEMethod != ""
+}
+
+// Get the protections of the current flow, from the time its URL is built by
+// AuthCodeURL, Prepare or Open until it ends. ErrNotStarted is returned if
+// there is no flow.
+func (d *Dialog) Protections() (Protections, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	f := d.flow
+	if f == nil {
+		return Protections{}, ErrNotStarted
+	}
+	n := d.stateLength
+	if n == 0 {
+		n = stateLength
+	}
+	return Protections{PKCEMethod: f.pkceMethod, Nonce: f.nonce, State: f.state, StateLength: n}, nil
+}
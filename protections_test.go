@@ -0,0 +1,50 @@
+package oauthdialog
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+func TestProtections(t *testing.T) {
+	d := New(testConfig(), WithPKCE(), WithStateLength(40))
+	if _, err := d.Protections(); !errors.Is(err, ErrNotStarted) {
+		t.Fatalf("got error %v before the flow, want ErrNotStarted", err)
+	}
+
+	if _, err := d.AuthCodeURL(oauth2.SetAuthURLParam("nonce", "n")); err != nil {
+		t.Fatal(err)
+	}
+	p, err := d.Protections()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.PKCE() || p.PKCEMethod != "S256" {
+		t.Errorf("got PKCE method %q, want S256", p.PKCEMethod)
+	}
+	if !p.Nonce {
+		t.Error("nonce not reported")
+	}
+	if p.StateLength != 40 || len(p.State) != base64.RawURLEncoding.EncodedLen(40) {
+		t.Errorf("got state %q of %v bytes, want 40 bytes", p.State, p.StateLength)
+	}
+}
+
+func TestProtectionsDefault(t *testing.T) {
+	d := New(testConfig())
+	if _, err := d.AuthCodeURL(); err != nil {
+		t.Fatal(err)
+	}
+	p, err := d.Protections()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.PKCE() || p.Nonce {
+		t.Errorf("got %+v, want neither PKCE nor a nonce", p)
+	}
+	if p.State == "" || p.StateLength != stateLength {
+		t.Errorf("got state %q of %v bytes, want %v bytes", p.State, p.StateLength, stateLength)
+	}
+}
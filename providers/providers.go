@@ -0,0 +1,86 @@
+// Dialogs preconfigured for common OAuth2 providers.
+package providers
+
+import (
+	"github.com/badarsebard/go-oauthdialog"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/endpoints"
+)
+
+// The config of a provider and the options handling its quirks, to create a
+// dialog with more options.
+type Preset struct {
+	Config  *oauth2.Config
+	Options []oauthdialog.Option
+}
+
+// Create a dialog for the provider, with opts applied after the preset's
+// options.
+func (p Preset) New(opts ...oauthdialog.Option) *oauthdialog.Dialog {
+	return oauthdialog.New(p.Config, append(append([]oauthdialog.Option(nil), p.Options...), opts...)...)
+}
+
+func config(endpoint oauth2.Endpoint, clientID, clientSecret string, scopes []string) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Endpoint:     endpoint,
+		Scopes:       scopes,
+	}
+}
+
+// Get the preset for Google. A refresh token is requested, which Google only
+// issues reliably with access_type=offline and prompt=consent.
+func GooglePreset(clientID, clientSecret string, scopes ...string) Preset {
+	return Preset{
+		Config: config(endpoints.Google, clientID, clientSecret, scopes),
+		Options: []oauthdialog.Option{
+			oauthdialog.WithRefreshToken(),
+			oauthdialog.WithRevocationURL("https://oauth2.googleapis.com/revoke"),
+		},
+	}
+}
+
+// Create a dialog for Google, see GooglePreset.
+func Google(clientID, clientSecret string, scopes ...string) *oauthdialog.Dialog {
+	return GooglePreset(clientID, clientSecret, scopes...).New()
+}
+
+// Get the preset for GitHub. GitHub always echoes the state, which is checked
+// as for any provider. No refresh token is requested since OAuth apps get
+// tokens which don't expire and come without one, and PKCE isn't enabled;
+// add WithRefreshToken or WithPKCE for GitHub Apps using them.
+func GitHubPreset(clientID, clientSecret string, scopes ...string) Preset {
+	return Preset{Config: config(endpoints.GitHub, clientID, clientSecret, scopes)}
+}
+
+// Create a dialog for GitHub, see GitHubPreset.
+func GitHub(clientID, clientSecret string, scopes ...string) *oauthdialog.Dialog {
+	return GitHubPreset(clientID, clientSecret, scopes...).New()
+}
+
+// Get the preset for the Microsoft identity platform, for both work and
+// personal accounts, see MicrosoftTenantPreset.
+func MicrosoftPreset(clientID, clientSecret string, scopes ...string) Preset {
+	return MicrosoftTenantPreset("common", clientID, clientSecret, scopes...)
+}
+
+// Get the preset for the Microsoft identity platform signing in accounts of
+// tenant, which is a tenant ID or domain, or one of "common",
+// "organizations" and "consumers". A refresh token is requested with the
+// offline_access scope, and the response is posted to the callback with
+// response_mode=form_post so the code doesn't end up in the browser history.
+func MicrosoftTenantPreset(tenant, clientID, clientSecret string, scopes ...string) Preset {
+	return Preset{
+		Config: config(endpoints.AzureAD(tenant), clientID, clientSecret, scopes),
+		Options: []oauthdialog.Option{
+			oauthdialog.WithScopes("offline_access"),
+			oauthdialog.WithAuthCodeOptions(oauth2.SetAuthURLParam("response_mode", "form_post")),
+		},
+	}
+}
+
+// Create a dialog for the Microsoft identity platform, see MicrosoftPreset.
+func Microsoft(clientID, clientSecret string, scopes ...string) *oauthdialog.Dialog {
+	return MicrosoftPreset(clientID, clientSecret, scopes...).New()
+}
@@ -0,0 +1,68 @@
+package providers
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/badarsebard/go-oauthdialog"
+)
+
+// Get the query of the authorization URL of d's first flow.
+func authQuery(t *testing.T, d *oauthdialog.Dialog) url.Values {
+	authURL, err := d.AuthCodeURL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Query()
+}
+
+func TestGooglePresetOptions(t *testing.T) {
+	q := authQuery(t, GooglePreset("id", "secret", "email").New(oauthdialog.WithPKCE()))
+	if q.Get("access_type") != "offline" || q.Get("prompt") != "consent" {
+		t.Errorf("got query %v, want a refresh token requested", q)
+	}
+	if q.Get("code_challenge_method") != "S256" {
+		t.Errorf("got query %v, want PKCE", q)
+	}
+}
+
+func TestMicrosoftScopes(t *testing.T) {
+	q := authQuery(t, Microsoft("id", "", "User.Read"))
+	scopes := strings.Fields(q.Get("scope"))
+	if len(scopes) != 2 || scopes[0] != "User.Read" || scopes[1] != "offline_access" {
+		t.Errorf("got scopes %v", scopes)
+	}
+}
+
+func TestMicrosoftFormPost(t *testing.T) {
+	if q := authQuery(t, Microsoft("id", "")); q.Get("response_mode") != "form_post" {
+		t.Errorf("got query %v, want response_mode=form_post", q)
+	}
+}
+
+func TestMicrosoftTenantPreset(t *testing.T) {
+	p := MicrosoftTenantPreset("contoso.example", "id", "", "User.Read")
+	for _, endpoint := range []string{p.Config.Endpoint.AuthURL, p.Config.Endpoint.TokenURL} {
+		if !strings.HasPrefix(endpoint, "https://login.microsoftonline.com/contoso.example/") {
+			t.Errorf("got endpoint %v, want the tenant's", endpoint)
+		}
+	}
+	if !strings.Contains(MicrosoftPreset("id", "").Config.Endpoint.AuthURL, "/common/") {
+		t.Errorf("got endpoint %v, want the common tenant", MicrosoftPreset("id", "").Config.Endpoint.AuthURL)
+	}
+}
+
+func TestGitHubPreset(t *testing.T) {
+	q := authQuery(t, GitHub("id", "secret", "repo"))
+	if q.Get("access_type") != "" || q.Get("code_challenge") != "" || q.Get("response_mode") != "" {
+		t.Errorf("got query %v, want neither a refresh token, PKCE nor a response mode", q)
+	}
+	if q.Get("state") == "" {
+		t.Errorf("got query %v, want a state", q)
+	}
+}
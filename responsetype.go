@@ -0,0 +1,83 @@
+package oauthdialog
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var (
+	// ErrInvalidResponseType is returned when the response type set with
+	// WithResponseType isn't a supported combination.
+	ErrInvalidResponseType = errors.New("Invalid response type")
+	// ErrIncompleteCallback is returned when the provider redirects without
+	// all the values the response type asked for.
+	ErrIncompleteCallback = errors.New("Callback is missing requested values")
+)
+
+// The values of a response_type, as defined in RFC 6749 and OpenID Connect
+// Core, which may be combined.
+var responseTypeValues = map[string]bool{
+	"code":     true,
+	"token":    true,
+	"id_token": true,
+}
+
+// A set of response_type values.
+type responseType map[string]bool
+
+// Parse and validate a response type given as a space-separated list or as
+// separate values. "none" can't be combined with any other value.
+func parseResponseType(values []string) (responseType, error) {
+	rt := responseType{}
+	for _, v := range values {
+		for _, name := range strings.Fields(v) {
+			if name != "none" && !responseTypeValues[name] {
+				return nil, fmt.Errorf("%w: unknown value %q", ErrInvalidResponseType, name)
+			}
+			if rt[name] {
+				return nil, fmt.Errorf("%w: repeated value %q", ErrInvalidResponseType, name)
+			}
+			rt[name] = true
+		}
+	}
+	if len(rt) == 0 {
+		return nil, fmt.Errorf("%w: no value", ErrInvalidResponseType)
+	}
+	if rt["none"] && len(rt) > 1 {
+		return nil, fmt.Errorf("%w: none can't be combined", ErrInvalidResponseType)
+	}
+	return rt, nil
+}
+
+// Get the response_type parameter, with values in a stable order.
+func (rt responseType) String() string {
+	names := make([]string, 0, len(rt))
+	for name := range rt {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, " ")
+}
+
+// Check whether the response is returned in the fragment by default, which
+// the local server can't see, rather than in the query.
+func (rt responseType) fragment() bool {
+	return rt["token"] || rt["id_token"]
+}
+
+// Get the values asked for by rt which are missing from res.
+func (rt responseType) missing(res *handlerResponse) []string {
+	var missing []string
+	if rt["code"] && res.Code == "" {
+		missing = append(missing, "code")
+	}
+	if rt["token"] && res.AccessToken == "" {
+		missing = append(missing, "access_token")
+	}
+	if rt["id_token"] && res.IdToken == "" {
+		missing = append(missing, "id_token")
+	}
+	return missing
+}
@@ -0,0 +1,99 @@
+package oauthdialog
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+)
+
+func TestParseResponseType(t *testing.T) {
+	for _, values := range [][]string{
+		{"code"},
+		{"token"},
+		{"id_token"},
+		{"code id_token"},
+		{"code", "token"},
+		{"id_token token"},
+		{" code  id_token token "},
+		{"none"},
+	} {
+		if _, err := parseResponseType(values); err != nil {
+			t.Errorf("parseResponseType(%q) failed: %v", values, err)
+		}
+	}
+	for _, values := range [][]string{
+		nil,
+		{" "},
+		{"code code"},
+		{"code", "code"},
+		{"code device"},
+		{"none code"},
+		{"Code"},
+	} {
+		if _, err := parseResponseType(values); !errors.Is(err, ErrInvalidResponseType) {
+			t.Errorf("parseResponseType(%q) = %v, want ErrInvalidResponseType", values, err)
+		}
+	}
+}
+
+func TestResponseTypeParams(t *testing.T) {
+	tests := []struct {
+		values []string
+		want   string
+		mode   string
+	}{
+		{[]string{"code"}, "code", ""},
+		{[]string{"token", "code"}, "code token", "form_post"},
+		{[]string{"id_token code"}, "code id_token", "form_post"},
+		{[]string{"id_token"}, "id_token", "form_post"},
+	}
+	for _, test := range tests {
+		q := authQuery(t, New(testConfig(), WithResponseType(test.values...)))
+		if got := q.Get("response_type"); got != test.want {
+			t.Errorf("got response_type %q for %q, want %q", got, test.values, test.want)
+		}
+		if got := q.Get("response_mode"); got != test.mode {
+			t.Errorf("got response_mode %q for %q, want %q", got, test.values, test.mode)
+		}
+	}
+
+	q := authQuery(t, New(testConfig(), WithResponseType("code", "id_token"), WithFragmentCapture()))
+	if got := q.Get("response_mode"); got != "" {
+		t.Errorf("got response_mode %q with fragment capture, want none", got)
+	}
+
+	if _, err := New(testConfig(), WithResponseType("code", "device")).AuthCodeURL(); !errors.Is(err, ErrInvalidResponseType) {
+		t.Errorf("got error %v, want ErrInvalidResponseType", err)
+	}
+}
+
+func TestResponseTypeCallback(t *testing.T) {
+	tests := []struct {
+		values []string
+		params url.Values
+		err    error
+	}{
+		{[]string{"code"}, url.Values{"code": {"code"}}, nil},
+		{[]string{"token"}, url.Values{"access_token": {"access"}, "token_type": {"bearer"}}, nil},
+		{[]string{"id_token"}, url.Values{"id_token": {"id"}}, nil},
+		{[]string{"code id_token"}, url.Values{"code": {"code"}, "id_token": {"id"}}, nil},
+		{[]string{"code id_token"}, url.Values{"code": {"code"}}, ErrIncompleteCallback},
+		{[]string{"code token"}, url.Values{"access_token": {"access"}}, ErrIncompleteCallback},
+		{[]string{"none"}, nil, nil},
+	}
+	for _, test := range tests {
+		d := New(testConfig(), WithResponseType(test.values...))
+		cb := callbackURL(t, d, nil)
+		u, _ := url.Parse(cb)
+		form := url.Values{"state": {u.Query().Get("state")}}
+		for name, v := range test.params {
+			form[name] = v
+		}
+		postCallback(d, cb, form)
+		_, err := d.Wait(context.Background())
+		if !errors.Is(err, test.err) {
+			t.Errorf("got error %v for %q with %v, want %v", err, test.values, test.params, test.err)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package oauthdialog
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Version of the JSON encoding of a Result.
+const resultVersion = 1
+
+// The JSON encoding of a Result, with stable field names. Opened and
+// Received only matter to the flow which returned the result and aren't
+// kept.
+type resultJSON struct {
+	Version      int                    `json:"version"`
+	Code         string                 `json:"code,omitempty"`
+	IdToken      string                 `json:"id_token,omitempty"`
+	AccessToken  string                 `json:"access_token,omitempty"`
+	TokenType    string                 `json:"token_type,omitempty"`
+	State        string                 `json:"state,omitempty"`
+	Expiry       *time.Time             `json:"expiry,omitempty"`
+	Scope        string                 `json:"scope,omitempty"`
+	SessionState string                 `json:"session_state,omitempty"`
+	Issuer       string                 `json:"iss,omitempty"`
+	Extra        map[string]string      `json:"extra,omitempty"`
+	Mode         DeliveryMode           `json:"mode,omitempty"`
+	RedirectURL  string                 `json:"redirect_url,omitempty"`
+	StateClaims  map[string]interface{} `json:"state_claims,omitempty"`
+}
+
+// MarshalJSON encodes the result with a version, e.g. to cache it.
+func (r Result) MarshalJSON() ([]byte, error) {
+	v := resultJSON{
+		Version:      resultVersion,
+		Code:         r.Code,
+		IdToken:      r.IdToken,
+		AccessToken:  r.AccessToken,
+		TokenType:    r.TokenType,
+		State:        r.State,
+		Scope:        r.Scope,
+		SessionState: r.SessionState,
+		Issuer:       r.Issuer,
+		Extra:        r.Extra,
+		Mode:         r.Mode,
+		RedirectURL:  r.RedirectURL,
+		StateClaims:  r.StateClaims,
+	}
+	if !r.Expiry.IsZero() {
+		v.Expiry = &r.Expiry
+	}
+	return json.Marshal(&v)
+}
+
+// UnmarshalJSON decodes a result encoded by MarshalJSON.
+func (r *Result) UnmarshalJSON(b []byte) error {
+	var v resultJSON
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	if v.Version != resultVersion {
+		return fmt.Errorf("Unsupported result version %v", v.Version)
+	}
+
+	*r = Result{
+		Code:         v.Code,
+		IdToken:      v.IdToken,
+		AccessToken:  v.AccessToken,
+		TokenType:    v.TokenType,
+		State:        v.State,
+		Scope:        v.Scope,
+		SessionState: v.SessionState,
+		Issuer:       v.Issuer,
+		Extra:        v.Extra,
+		Mode:         v.Mode,
+		RedirectURL:  v.RedirectURL,
+		StateClaims:  v.StateClaims,
+	}
+	if v.Expiry != nil {
+		r.Expiry = *v.Expiry
+	}
+	return nil
+}
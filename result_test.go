@@ -0,0 +1,74 @@
+package oauthdialog
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResultJSON(t *testing.T) {
+	res := Result{
+		Code:         "code",
+		IdToken:      "id",
+		AccessToken:  "access",
+		TokenType:    "bearer",
+		State:        "state",
+		Expiry:       time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC),
+		Scope:        "openid email",
+		SessionState: "session",
+		Issuer:       "https://provider.example",
+		Extra:        map[string]string{"custom": "value"},
+		Mode:         DeliveryFormPost,
+		RedirectURL:  "http://127.0.0.1:8080",
+		StateClaims:  map[string]interface{}{"return_to": "/home", "n": float64(1)},
+		Opened:       true,
+		Received:     true,
+	}
+	b, err := json.Marshal(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{`"version":1`, `"code":`, `"id_token":`, `"session_state":`, `"iss":`, `"mode":"form_post"`, `"state_claims":`} {
+		if !strings.Contains(string(b), name) {
+			t.Errorf("%v missing from %s", name, b)
+		}
+	}
+
+	var got Result
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := res
+	want.Opened, want.Received = false, false
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestResultJSONEmpty(t *testing.T) {
+	b, err := json.Marshal(&Result{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{"version":1}` {
+		t.Errorf("got %s for an empty result", b)
+	}
+	var got Result
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, Result{}) {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestResultJSONVersion(t *testing.T) {
+	var res Result
+	for _, b := range []string{`{"code":"code"}`, `{"version":2,"code":"code"}`} {
+		if err := json.Unmarshal([]byte(b), &res); err == nil {
+			t.Errorf("decoded %s", b)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package oauthdialog
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/oauth2"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ErrNoRevocationURL is returned by Revoke when no revocation endpoint is
+// configured.
+var ErrNoRevocationURL = errors.New("No revocation endpoint")
+
+// An error returned when the revocation endpoint rejects a token.
+type RevokeError struct {
+	// The HTTP status code returned by the endpoint, or zero if no response
+	// was received.
+	StatusCode int
+
+	err error
+}
+
+func (e *RevokeError) Error() string {
+	if e.StatusCode == 0 {
+		return fmt.Sprintf("Token revocation failed: %v", e.err)
+	}
+	return fmt.Sprintf("Token revocation failed with status %v: %v", e.StatusCode, e.err)
+}
+
+// Unwrap returns the underlying error, an *OAuthError if the endpoint
+// returned one.
+func (e *RevokeError) Unwrap() error {
+	return e.err
+}
+
+// Revoke tok at the endpoint set with WithRevocationURL, as defined in RFC
+// 7009, e.g. with defer once a one-off task is done. Its refresh token is
+// revoked if it has one, which also revokes its access tokens with most
+// providers, otherwise its access token. A token the endpoint reports as
+// invalid is already unusable, which isn't an error.
+func (d *Dialog) Revoke(ctx context.Context, tok *oauth2.Token) error {
+	if d.config == nil {
+		return ErrNilConfig
+	}
+	if d.revocationURL == "" {
+		return ErrNoRevocationURL
+	}
+	if tok == nil {
+		return nil
+	}
+
+	params := url.Values{"token": {tok.RefreshToken}, "token_type_hint": {"refresh_token"}}
+	if tok.RefreshToken == "" {
+		params = url.Values{"token": {tok.AccessToken}, "token_type_hint": {"access_token"}}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.revocationURL, nil)
+	if err != nil {
+		return err
+	}
+	if err := d.authenticateClient(req, params); err != nil {
+		return err
+	}
+	body := params.Encode()
+	req.Body = io.NopCloser(strings.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := httpClient(d.userAgentContext(ctx)).Do(req)
+	if err != nil {
+		return &RevokeError{err: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	var errResp struct {
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+		ErrorURI         string `json:"error_uri"`
+	}
+	if json.Unmarshal(b, &errResp) == nil && errResp.Error != "" {
+		if errResp.Error == "invalid_token" {
+			return nil
+		}
+		return &RevokeError{StatusCode: resp.StatusCode, err: &OAuthError{
+			Code:        errResp.Error,
+			Description: errResp.ErrorDescription,
+			URI:         errResp.ErrorURI,
+		}}
+	}
+	return &RevokeError{StatusCode: resp.StatusCode, err: fmt.Errorf("Unexpected response: %v", redactBody(b))}
+}
@@ -0,0 +1,42 @@
+package oauthdialog
+
+import (
+	"fmt"
+	"strings"
+)
+
+// An error returned by Token when the provider didn't grant all the scopes
+// set with WithRequiredScopes.
+type MissingScopesError struct {
+	Missing []string
+}
+
+func (e *MissingScopesError) Error() string {
+	return fmt.Sprintf("Scopes not granted: %v", strings.Join(e.Missing, " "))
+}
+
+// Split space-delimited scope lists, trimming whitespace and removing
+// duplicates. Scopes are case-sensitive and left as-is.
+func normalizeScopes(lists ...string) []string {
+	var fields [][]string
+	for _, l := range lists {
+		fields = append(fields, strings.Fields(l))
+	}
+	return mergeScopes(fields...)
+}
+
+// Get the required scopes missing from the granted ones.
+func missingScopes(required, granted []string) []string {
+	grantedSet := make(map[string]bool)
+	for _, s := range normalizeScopes(granted...) {
+		grantedSet[s] = true
+	}
+
+	var missing []string
+	for _, s := range normalizeScopes(required...) {
+		if !grantedSet[s] {
+			missing = append(missing, s)
+		}
+	}
+	return missing
+}
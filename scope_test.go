@@ -0,0 +1,50 @@
+package oauthdialog
+
+import (
+	"context"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestMissingScopes(t *testing.T) {
+	tests := []struct {
+		required []string
+		granted  string
+		missing  []string
+	}{
+		{[]string{"openid", "email"}, "email openid", nil},
+		{[]string{" openid ", "email"}, "  email   openid ", nil},
+		{[]string{"openid email", "openid"}, "openid\temail", nil},
+		{[]string{"openid", "profile", "email"}, "email", []string{"openid", "profile"}},
+		{[]string{"Email"}, "email", []string{"Email"}},
+	}
+	for _, test := range tests {
+		missing := missingScopes(test.required, []string{test.granted})
+		if !reflect.DeepEqual(missing, test.missing) {
+			t.Errorf("missingScopes(%q, %q) = %q, want %q", test.required, test.granted, missing, test.missing)
+		}
+	}
+}
+
+func TestRequiredScopes(t *testing.T) {
+	srv, _ := tokenServer(t, `{"access_token":"access","token_type":"bearer","scope":" profile  openid "}`)
+	conf := testConfig()
+	conf.Endpoint.TokenURL = srv.URL
+	opener := WithOpener(redirectOpener(url.Values{"code": {"code"}}))
+
+	d := New(conf, opener, WithRequiredScopes("openid", "profile "))
+	if _, err := d.Token(context.Background()); err != nil {
+		t.Errorf("got error %v, want none", err)
+	}
+
+	d = New(conf, opener, WithRequiredScopes("openid", "email"))
+	_, err := d.Token(context.Background())
+	missingErr, ok := err.(*MissingScopesError)
+	if !ok {
+		t.Fatalf("got error %v, want a *MissingScopesError", err)
+	}
+	if !reflect.DeepEqual(missingErr.Missing, []string{"email"}) {
+		t.Errorf("got missing scopes %q, want email", missingErr.Missing)
+	}
+}
@@ -3,20 +3,68 @@ package oauthdialog
 import (
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"time"
 )
 
-const stateLength = 32
+const (
+	// Default number of random bytes drawn for the state.
+	stateLength = 32
+	// Minimum number of random bytes drawn for the state.
+	minStateLength = 16
+)
+
+// ErrStateTooShort is returned when the configured state length is below the
+// minimum of 16 bytes.
+var ErrStateTooShort = errors.New("State length too short")
+
+// ErrEntropy is returned when the system's secure random number generator
+// fails, so that no state, PKCE verifier or nonce can be generated. On Linux,
+// this may happen early during boot or in sandboxes denying the getrandom
+// system call or access to /dev/urandom.
+var ErrEntropy = errors.New("Secure random number generator unavailable")
+
+// Reading random bytes is retried, in case the failure is transient.
+const (
+	entropyAttempts   = 3
+	entropyRetryDelay = 10 * time.Millisecond
+)
+
+// ErrEmptyState is returned when the state of a flow is empty, which would
+// match any callback without a state.
+var ErrEmptyState = errors.New("Generated state is empty")
+
+// Source of the random states, PKCE verifiers and nonces. Only tests may
+// replace it, to make them deterministic.
+var randReader io.Reader = rand.Reader
 
 func randomString(n int) (string, error) {
 	b := make([]byte, n)
-	_, err := rand.Read(b)
+	var err error
+	for attempt := 0; attempt < entropyAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(entropyRetryDelay)
+		}
+		if _, err = io.ReadFull(randReader, b); err == nil {
+			break
+		}
+	}
 	if err != nil {
-		return "", err
+		return "", fmt.Errorf("%w: %v", ErrEntropy, err)
 	}
 
 	return base64.RawURLEncoding.EncodeToString(b), nil
 }
 
-func generateState() (string, error) {
-	return randomString(stateLength)
+// Generate a state from n random bytes, or the default length if n is zero.
+func generateState(n int) (string, error) {
+	if n == 0 {
+		n = stateLength
+	}
+	if n < minStateLength {
+		return "", ErrStateTooShort
+	}
+	return randomString(n)
 }
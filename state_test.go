@@ -0,0 +1,147 @@
+package oauthdialog
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// Replace the random source with r until the test ends.
+func setRandReader(t *testing.T, r io.Reader) {
+	prev := randReader
+	t.Cleanup(func() { randReader = prev })
+	randReader = r
+}
+
+// A reader whose first reads fail.
+type flakyReader struct {
+	failures int
+}
+
+func (r *flakyReader) Read(p []byte) (int, error) {
+	if r.failures > 0 {
+		r.failures--
+		return 0, errors.New("getrandom: resource temporarily unavailable")
+	}
+	for i := range p {
+		p[i] = 1
+	}
+	return len(p), nil
+}
+
+func TestDeterministicAuthCodeURL(t *testing.T) {
+	urls := make([]string, 2)
+	for i := range urls {
+		setRandReader(t, bytes.NewReader(bytes.Repeat([]byte{0}, 1024)))
+		authURL, err := New(testConfig(), WithPKCE()).AuthCodeURL()
+		if err != nil {
+			t.Fatal(err)
+		}
+		urls[i] = authURL
+	}
+	if urls[0] != urls[1] {
+		t.Fatalf("got different URLs %q and %q", urls[0], urls[1])
+	}
+
+	u, _ := url.Parse(urls[0])
+	if state := u.Query().Get("state"); state != "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA" {
+		t.Errorf("got state %q", state)
+	}
+}
+
+func TestEntropyRetry(t *testing.T) {
+	setRandReader(t, &flakyReader{failures: entropyAttempts - 1})
+	if _, err := generateState(0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEntropyFailure(t *testing.T) {
+	setRandReader(t, &flakyReader{failures: entropyAttempts})
+	if _, err := New(testConfig()).AuthCodeURL(); !errors.Is(err, ErrEntropy) {
+		t.Fatalf("got error %v, want ErrEntropy", err)
+	}
+}
+
+// Start two flows on d and get the callback URL of the first, superseded one.
+func supersededCallbackURL(t *testing.T, d *Dialog) (string, string) {
+	cb := callbackURL(t, d, url.Values{"code": {"code"}})
+	verifier := d.verifier
+	if _, err := d.AuthCodeURL(); err != nil {
+		t.Fatal(err)
+	}
+	return cb, verifier
+}
+
+func TestWithStateGrace(t *testing.T) {
+	d := New(testConfig(), WithPKCE(), WithStateGrace(time.Minute))
+	cb, verifier := supersededCallbackURL(t, d)
+	d.CallbackHandler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, cb, nil))
+	res, err := d.Wait(context.Background())
+	if err != nil {
+		t.Fatalf("got error %v for the previous state", err)
+	}
+	if res.Code != "code" {
+		t.Errorf("got code %q", res.Code)
+	}
+	if verifier == "" || d.verifier != verifier {
+		t.Error("the previous flow's verifier wasn't restored")
+	}
+}
+
+func TestWithStateGraceExpired(t *testing.T) {
+	for name, opts := range map[string][]Option{
+		"expired":  {WithStateGrace(time.Millisecond)},
+		"no grace": nil,
+	} {
+		d := New(testConfig(), opts...)
+		cb, _ := supersededCallbackURL(t, d)
+		time.Sleep(10 * time.Millisecond)
+		d.CallbackHandler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, cb, nil))
+		if _, err := d.Wait(context.Background()); err != ErrStateMismatch {
+			t.Errorf("%v: got error %v for the previous state, want ErrStateMismatch", name, err)
+		}
+	}
+}
+
+// A reader which always fails.
+type failingReader struct{}
+
+func (failingReader) Read(p []byte) (int, error) {
+	return 0, errors.New("getrandom: function not implemented")
+}
+
+func TestEntropyFailurePaths(t *testing.T) {
+	tests := map[string]func() error{
+		"Open": func() error {
+			_, err := New(testConfig(), WithOpener(redirectOpener(nil))).OpenContext(context.Background())
+			return err
+		},
+		"Prepare": func() error {
+			_, err := New(testConfig()).Prepare()
+			return err
+		},
+		"PKCE": func() error {
+			// Enough for the state only
+			setRandReader(t, io.MultiReader(bytes.NewReader(make([]byte, stateLength)), failingReader{}))
+			d := New(testConfig(), WithPKCE())
+			_, err := d.AuthCodeURL()
+			if d.currentFlow() != nil {
+				t.Errorf("flow left open after the PKCE failure")
+			}
+			return err
+		},
+	}
+	for name, test := range tests {
+		setRandReader(t, failingReader{})
+		if err := test(); !errors.Is(err, ErrEntropy) {
+			t.Errorf("%v: got error %v, want ErrEntropy", name, err)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package oauthdialog
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrNoFlowState is returned by StateStore.Load when no state is stored
+// under the given ID.
+var ErrNoFlowState = errors.New("No stored flow state")
+
+// The secrets of a flow needed to complete it, e.g. after a restart or from
+// another process.
+type FlowState struct {
+	State       string `json:"state"`
+	Verifier    string `json:"verifier,omitempty"`
+	RedirectURL string `json:"redirect_url,omitempty"`
+}
+
+// Storage for the state of flows in progress, e.g. in memory, on disk or in
+// the OS keyring. Flows are identified by their state parameter.
+type StateStore interface {
+	// Save the state of a flow, replacing any stored under id.
+	Save(id string, data FlowState) error
+	// Load the state of a flow, or return ErrNoFlowState.
+	Load(id string) (FlowState, error)
+	// Delete the state of a flow. Deleting a missing state isn't an error.
+	Delete(id string) error
+}
+
+// A StateStore keeping the state of flows in memory, e.g. to share it between
+// the dialogs of a process. The zero value is ready to use.
+type MemoryStateStore struct {
+	mu     sync.Mutex
+	states map[string]FlowState
+}
+
+func (s *MemoryStateStore) Save(id string, data FlowState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.states == nil {
+		s.states = make(map[string]FlowState)
+	}
+	s.states[id] = data
+	return nil
+}
+
+func (s *MemoryStateStore) Load(id string) (FlowState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.states[id]
+	if !ok {
+		return FlowState{}, ErrNoFlowState
+	}
+	return data, nil
+}
+
+func (s *MemoryStateStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.states, id)
+	return nil
+}
+
+// Save the secrets of the current flow to the store, if any.
+func (d *Dialog) saveFlowState() error {
+	if d.stateStore == nil {
+		return nil
+	}
+	d.mu.Lock()
+	data := FlowState{
+		State:       d.flowID,
+		Verifier:    d.verifier,
+		RedirectURL: d.flowConfig().RedirectURL,
+	}
+	d.mu.Unlock()
+	return d.stateStore.Save(data.State, data)
+}
+
+// Resume the flow whose state parameter is id from the store set with
+// WithStateStore, so that Exchange completes it with its PKCE verifier and
+// redirect URI, e.g. after the application restarted between AuthCodeURL
+// and the callback.
+func (d *Dialog) Resume(id string) error {
+	if d.stateStore == nil {
+		return ErrNoFlowState
+	}
+	data, err := d.stateStore.Load(id)
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	d.flowID = data.State
+	d.verifier = data.Verifier
+	d.redirectURL = data.RedirectURL
+	d.mu.Unlock()
+	return nil
+}
+
+// Delete the state of the current flow from the store, if any, once it
+// can't be used anymore.
+func (d *Dialog) deleteFlowState() error {
+	d.mu.Lock()
+	id := d.flowID
+	d.flowID = ""
+	d.mu.Unlock()
+	if d.stateStore == nil || id == "" {
+		return nil
+	}
+	return d.stateStore.Delete(id)
+}
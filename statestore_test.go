@@ -0,0 +1,59 @@
+package oauthdialog
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestResume(t *testing.T) {
+	var verifier string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		verifier = r.PostForm.Get("code_verifier")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"access","token_type":"bearer"}`))
+	}))
+	defer srv.Close()
+	conf := testConfig()
+	conf.Endpoint.TokenURL = srv.URL
+	store := &MemoryStateStore{}
+
+	// The dialog starting the flow goes away before the callback
+	authURL, err := New(conf, WithPKCE(), WithStateStore(store)).AuthCodeURL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(authURL)
+	state := u.Query().Get("state")
+	saved, err := store.Load(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := New(conf, WithPKCE(), WithStateStore(store))
+	if err := d.Resume(state); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Exchange(context.Background(), "code"); err != nil {
+		t.Fatal(err)
+	}
+	if verifier == "" || verifier != saved.Verifier {
+		t.Errorf("got verifier %q, want %q", verifier, saved.Verifier)
+	}
+	if _, err := store.Load(state); err != ErrNoFlowState {
+		t.Errorf("got error %v after the exchange, want ErrNoFlowState", err)
+	}
+}
+
+func TestResumeUnknown(t *testing.T) {
+	d := New(testConfig(), WithStateStore(&MemoryStateStore{}))
+	if err := d.Resume("unknown"); err != ErrNoFlowState {
+		t.Errorf("got error %v, want ErrNoFlowState", err)
+	}
+	if err := New(testConfig()).Resume("unknown"); err != ErrNoFlowState {
+		t.Errorf("got error %v without a store, want ErrNoFlowState", err)
+	}
+}
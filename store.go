@@ -0,0 +1,200 @@
+package oauthdialog
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/oauth2"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Version of the format written by FileTokenStore.
+const tokenFileVersion = 1
+
+// ErrNoToken is returned by TokenStore.Load when no token is stored.
+var ErrNoToken = errors.New("No stored token")
+
+// Persistent storage for a token, e.g. a file or the OS keyring.
+type TokenStore interface {
+	// Load the stored token, or return ErrNoToken.
+	Load() (*oauth2.Token, error)
+	// Replace the stored token.
+	Save(tok *oauth2.Token) error
+}
+
+// A TokenStore keeping the token in a file only readable by its owner.
+type FileTokenStore struct {
+	Path string
+	// If set, the token is encrypted with AES-GCM. It must be 16, 24 or 32
+	// bytes long.
+	Key []byte
+}
+
+type tokenFile struct {
+	Version int           `json:"version"`
+	Token   *oauth2.Token `json:"token"`
+}
+
+func (s *FileTokenStore) Load() (*oauth2.Token, error) {
+	b, err := os.ReadFile(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNoToken
+	} else if err != nil {
+		return nil, err
+	}
+
+	if s.Key != nil {
+		if b, err = decrypt(s.Key, b); err != nil {
+			return nil, err
+		}
+	}
+
+	var f tokenFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, err
+	}
+	if f.Version != tokenFileVersion {
+		return nil, fmt.Errorf("Unsupported token file version %v", f.Version)
+	}
+	if f.Token == nil {
+		return nil, ErrNoToken
+	}
+	return f.Token, nil
+}
+
+func (s *FileTokenStore) Save(tok *oauth2.Token) error {
+	b, err := json.Marshal(&tokenFile{Version: tokenFileVersion, Token: tok})
+	if err != nil {
+		return err
+	}
+
+	if s.Key != nil {
+		if b, err = encrypt(s.Key, b); err != nil {
+			return err
+		}
+	}
+
+	// Write to a temporary file, created with 0600 permissions, and rename it
+	// so that the token is never partially written
+	f, err := os.CreateTemp(filepath.Dir(s.Path), filepath.Base(s.Path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), s.Path)
+}
+
+func encrypt(key, plaintext []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func decrypt(key, ciphertext []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("Encrypted token too short")
+	}
+	nonce, ciphertext := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, nil)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// A token source saving new tokens to a store.
+type storeTokenSource struct {
+	src   oauth2.TokenSource
+	store TokenStore
+
+	mu   sync.Mutex
+	last string
+}
+
+func (s *storeTokenSource) Token() (*oauth2.Token, error) {
+	tok, err := s.src.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if tok.AccessToken != s.last {
+		if err := s.store.Save(tok); err != nil {
+			return nil, err
+		}
+		s.last = tok.AccessToken
+	}
+	return tok, nil
+}
+
+// Get a token source from the token kept in store, refreshing it when needed.
+// If no token is stored, or if it has expired and can't be refreshed, the
+// dialog is opened as with Token. New tokens are saved to store.
+func (d *Dialog) StoredTokenSource(ctx context.Context, store TokenStore, opts ...oauth2.AuthCodeOption) (oauth2.TokenSource, error) {
+	tok, err := store.Load()
+	if err != nil && !errors.Is(err, ErrNoToken) {
+		return nil, err
+	}
+
+	last := ""
+	if tok != nil && !tok.Valid() && tok.RefreshToken != "" {
+		// The refresh token may have been revoked or have expired
+		if refreshed, err := d.flowConfig().TokenSource(d.userAgentContext(ctx), tok).Token(); err == nil {
+			tok = refreshed
+			if err := store.Save(tok); err != nil {
+				return nil, err
+			}
+		} else {
+			tok = nil
+		}
+	}
+	if tok == nil {
+		if tok, err = d.Token(ctx, opts...); err != nil {
+			return nil, err
+		}
+		if err := store.Save(tok); err != nil {
+			return nil, err
+		}
+	}
+	if tok.Valid() {
+		last = tok.AccessToken
+	}
+
+	src := &storeTokenSource{
+		src:   d.flowConfig().TokenSource(ctx, tok),
+		store: store,
+		last:  last,
+	}
+	return oauth2.ReuseTokenSource(tok, src), nil
+}
@@ -0,0 +1,106 @@
+package oauthdialog
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// A TokenStore keeping the token in memory.
+type memoryTokenStore struct {
+	tok *oauth2.Token
+}
+
+func (s *memoryTokenStore) Load() (*oauth2.Token, error) {
+	if s.tok == nil {
+		return nil, ErrNoToken
+	}
+	return s.tok, nil
+}
+
+func (s *memoryTokenStore) Save(tok *oauth2.Token) error {
+	s.tok = tok
+	return nil
+}
+
+// Start a token endpoint rejecting refresh tokens and issuing access tokens
+// named after the grant type.
+func rejectingRefreshServer(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		w.Header().Set("Content-Type", "application/json")
+		if r.PostForm.Get("grant_type") == "refresh_token" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		w.Write([]byte(`{"access_token":"new","token_type":"bearer","expires_in":3600}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestStoredTokenSourceRevokedRefreshToken(t *testing.T) {
+	conf := testConfig()
+	conf.Endpoint.TokenURL = rejectingRefreshServer(t).URL
+	d := New(conf, WithOpener(redirectOpener(url.Values{"code": {"code"}})))
+	store := &memoryTokenStore{tok: &oauth2.Token{
+		AccessToken:  "old",
+		RefreshToken: "revoked",
+		Expiry:       time.Now().Add(-time.Hour),
+	}}
+
+	src, err := d.StoredTokenSource(context.Background(), store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok, err := src.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.AccessToken != "new" || store.tok.AccessToken != "new" {
+		t.Errorf("got token %q, stored %q, want new ones from the dialog", tok.AccessToken, store.tok.AccessToken)
+	}
+}
+
+func TestFileTokenStore(t *testing.T) {
+	store := &FileTokenStore{
+		Path: filepath.Join(t.TempDir(), "token.json"),
+		Key:  []byte("0123456789abcdef"),
+	}
+	if _, err := store.Load(); err != ErrNoToken {
+		t.Fatalf("got error %v, want ErrNoToken", err)
+	}
+
+	expiry := time.Now().Add(time.Hour).Round(time.Second)
+	if err := store.Save(&oauth2.Token{AccessToken: "access", RefreshToken: "refresh", Expiry: expiry}); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(store.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("got permissions %v, want 0600", perm)
+	}
+
+	tok, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.AccessToken != "access" || tok.RefreshToken != "refresh" || !tok.Expiry.Equal(expiry) {
+		t.Errorf("got token %+v", tok)
+	}
+
+	wrongKey := &FileTokenStore{Path: store.Path, Key: []byte("fedcba9876543210")}
+	if _, err := wrongKey.Load(); err == nil {
+		t.Error("token decrypted with the wrong key")
+	}
+}
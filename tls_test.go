@@ -0,0 +1,89 @@
+package oauthdialog
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+// Get an opener checking that the callback server refuses handshakes below
+// TLS 1.3, then delivering a code over TLS 1.3.
+func tlsOpener(t *testing.T) Opener {
+	return func(ctx context.Context, authURL string) error {
+		u, err := url.Parse(authURL)
+		if err != nil {
+			return err
+		}
+		q := u.Query()
+		redirect, err := url.Parse(q.Get("redirect_uri"))
+		if err != nil {
+			return err
+		}
+		if redirect.Scheme != "https" {
+			t.Errorf("got redirect URI %v, want https", redirect)
+		}
+
+		old := &tls.Config{InsecureSkipVerify: true, MaxVersion: tls.VersionTLS12}
+		if conn, err := tls.Dial("tcp", redirect.Host, old); err == nil {
+			conn.Close()
+			t.Errorf("TLS 1.2 handshake succeeded, want it refused")
+		}
+
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true, MinVersion: tls.VersionTLS13},
+		}}
+		go func() {
+			resp, err := client.Get(redirect.String() + "?" + url.Values{"state": {q.Get("state")}, "code": {"code"}}.Encode())
+			if err == nil {
+				resp.Body.Close()
+			}
+		}()
+		return nil
+	}
+}
+
+func TestTLSMinVersion(t *testing.T) {
+	tests := []struct {
+		name string
+		opts func(t *testing.T) []Option
+	}{
+		{"WithTLSMinVersion", func(t *testing.T) []Option {
+			return []Option{WithTLS(), WithTLSMinVersion(tls.VersionTLS13)}
+		}},
+		{"WithTLSConfig", func(t *testing.T) []Option {
+			cert, err := selfSignedCert()
+			if err != nil {
+				t.Fatal(err)
+			}
+			return []Option{WithTLSConfig(&tls.Config{
+				Certificates: []tls.Certificate{cert},
+				MinVersion:   tls.VersionTLS13,
+			})}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := New(testConfig(), append(tt.opts(t), WithOpener(tlsOpener(t)))...)
+			res, err := d.OpenContext(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Code != "code" {
+				t.Errorf("got code %q, want %q", res.Code, "code")
+			}
+		})
+	}
+}
+
+func TestTLSDefaultMinVersion(t *testing.T) {
+	d := New(testConfig(), WithTLS())
+	conf, err := d.serverTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.MinVersion != tls.VersionTLS12 {
+		t.Errorf("got minimum version %x, want TLS 1.2", conf.MinVersion)
+	}
+}
@@ -0,0 +1,251 @@
+package oauthdialog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"golang.org/x/oauth2"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+const (
+	// Maximum number of bytes of the response body kept in an ExchangeError.
+	maxErrorBodyLength = 1024
+	// Default limit of the code exchange, see WithExchangeTimeout.
+	defaultExchangeTimeout = 30 * time.Second
+)
+
+var (
+	// ErrNoRefreshToken is returned by Token when WithRefreshToken is used
+	// but the provider didn't issue a refresh token.
+	ErrNoRefreshToken = errors.New("No refresh token returned")
+	// ErrExchangeTimeout is returned when the code exchange takes longer
+	// than the time set with WithExchangeTimeout.
+	ErrExchangeTimeout = errors.New("Token exchange timed out")
+)
+
+var (
+	jsonSecretRegexp = regexp.MustCompile(`("(?:access_token|refresh_token|id_token)"\s*:\s*)"[^"]*"`)
+	formSecretRegexp = regexp.MustCompile(`((?:^|&)(?:access_token|refresh_token|id_token)=)[^&]*`)
+)
+
+// An error returned when exchanging an authorization code for a token fails.
+type ExchangeError struct {
+	// The HTTP status code returned by the token endpoint, or zero if no
+	// response was received.
+	StatusCode int
+	// The response body, with tokens redacted and truncated.
+	Body string
+
+	err error
+}
+
+func newExchangeError(err error) *ExchangeError {
+	e := &ExchangeError{err: err}
+
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) {
+		if retrieveErr.Response != nil {
+			e.StatusCode = retrieveErr.Response.StatusCode
+		}
+		e.Body = redactBody(retrieveErr.Body)
+	}
+
+	return e
+}
+
+func (e *ExchangeError) Error() string {
+	if e.StatusCode == 0 {
+		return fmt.Sprintf("Token exchange failed: %v", e.err)
+	}
+	return fmt.Sprintf("Token exchange failed with status %v: %v", e.StatusCode, e.Body)
+}
+
+// Unwrap returns the error returned by the oauth2 package.
+func (e *ExchangeError) Unwrap() error {
+	return e.err
+}
+
+// Redact the tokens of b, then truncate it: truncating first could cut a
+// token short of what the regexps match.
+func redactBody(b []byte) string {
+	b = jsonSecretRegexp.ReplaceAll(b, []byte(`$1"REDACTED"`))
+	b = formSecretRegexp.ReplaceAll(b, []byte(`${1}REDACTED`))
+	if len(b) > maxErrorBodyLength {
+		b = b[:maxErrorBodyLength]
+	}
+	return string(b)
+}
+
+// Open the dialog and exchange the authorization code for a token. To handle
+// consent and token retrieval separately, call OpenContext then Exchange
+// instead.
+func (d *Dialog) Token(ctx context.Context, opts ...oauth2.AuthCodeOption) (*oauth2.Token, error) {
+	return d.TokenWithExchangeContext(ctx, ctx, opts...)
+}
+
+// Open the dialog as Token does, with ctx, then exchange the authorization
+// code with exchangeCtx, e.g. to cancel the exchange only, with the dialog
+// cleaned up as usual.
+func (d *Dialog) TokenWithExchangeContext(ctx, exchangeCtx context.Context, opts ...oauth2.AuthCodeOption) (*oauth2.Token, error) {
+	defer d.clearSecrets()
+	res, err := d.OpenContext(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	tok, err := d.Exchange(exchangeCtx, res.Code)
+	if err != nil {
+		return nil, err
+	}
+	if d.requireRefreshToken && tok.RefreshToken == "" {
+		return nil, ErrNoRefreshToken
+	}
+	// An omitted scope means the requested scopes were granted, see RFC 6749
+	// section 5.1
+	if granted, _ := tok.Extra("scope").(string); granted != "" {
+		if missing := missingScopes(d.requiredScopes, []string{granted}); len(missing) > 0 {
+			return nil, &MissingScopesError{Missing: missing}
+		}
+	}
+	return tok, nil
+}
+
+// Exchange an authorization code returned by Open for a token. Failures are
+// reported as an *ExchangeError. The exchange is limited to the time set with
+// WithExchangeTimeout.
+//
+// All fields of the token endpoint response are kept as extras of the token,
+// e.g. tok.Extra("id_token") or a non-standard "refresh_token_expires_in".
+// Values of JSON responses have their decoded JSON type, numbers being
+// float64; values of form-encoded responses are strings, except numbers,
+// which are int64 or float64. See IDToken and IssuedAt for the common cases.
+func (d *Dialog) Exchange(ctx context.Context, code string, opts ...oauth2.AuthCodeOption) (*oauth2.Token, error) {
+	if d.config == nil {
+		return nil, ErrNilConfig
+	}
+	conf := d.flowConfig()
+	var extra []oauth2.AuthCodeOption
+	for name, values := range d.endpointParams {
+		if len(values) > 0 {
+			extra = append(extra, oauth2.SetAuthURLParam(name, values[0]))
+		}
+	}
+	opts = append(extra, opts...)
+	if d.verifier != "" {
+		opts = append(opts, oauth2.SetAuthURLParam("code_verifier", d.verifier))
+	}
+	if d.clientKey != nil {
+		assertion, err := d.clientAssertion(conf.Endpoint.TokenURL)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts,
+			oauth2.SetAuthURLParam("client_assertion_type", clientAssertionType),
+			oauth2.SetAuthURLParam("client_assertion", assertion),
+		)
+		// The assertion replaces the secret
+		conf.ClientSecret = ""
+		conf.Endpoint.AuthStyle = oauth2.AuthStyleInParams
+	}
+	// Auto-detection would first try HTTP basic auth with an empty password,
+	// which some providers reject for public clients
+	if conf.ClientSecret == "" && conf.Endpoint.AuthStyle == oauth2.AuthStyleAutoDetect {
+		conf.Endpoint.AuthStyle = oauth2.AuthStyleInParams
+	}
+
+	exchangeCtx, expired, cancel := withTimeout(ctx, d.exchangeTimeout)
+	defer cancel()
+	tok, err := conf.Exchange(d.userAgentContext(exchangeCtx), code, opts...)
+	if err != nil {
+		if expired() {
+			return nil, fmt.Errorf("%w after %v", ErrExchangeTimeout, d.exchangeTimeout)
+		}
+		return nil, newExchangeError(err)
+	}
+	// A code can only be exchanged once, the flow is over
+	d.clearSecrets()
+	d.deleteFlowState()
+	return tok, nil
+}
+
+// Get the ID token returned along tok by an OpenID Connect provider, or an
+// empty string if there is none. It isn't validated.
+func IDToken(tok *oauth2.Token) string {
+	if tok == nil {
+		return ""
+	}
+	idToken, _ := tok.Extra("id_token").(string)
+	return idToken
+}
+
+// Get the time tok was issued, approximated by the time its response was
+// received, from its expiry and expires_in. The zero time is returned if tok
+// doesn't expire.
+func IssuedAt(tok *oauth2.Token) time.Time {
+	if tok == nil || tok.Expiry.IsZero() {
+		return time.Time{}
+	}
+	var expiresIn float64
+	switch v := tok.Extra("expires_in").(type) {
+	case float64:
+		expiresIn = v
+	case int64:
+		expiresIn = float64(v)
+	case string:
+		expiresIn, _ = strconv.ParseFloat(v, 64)
+	}
+	if expiresIn <= 0 {
+		return time.Time{}
+	}
+	return tok.Expiry.Add(-time.Duration(expiresIn) * time.Second)
+}
+
+// A transport setting the User-Agent of requests.
+type userAgentTransport struct {
+	userAgent string
+	base      http.RoundTripper
+}
+
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", t.userAgent)
+	return t.base.RoundTrip(req)
+}
+
+// Get a context whose HTTP client sends the User-Agent set with
+// WithUserAgent, if any.
+func (d *Dialog) userAgentContext(ctx context.Context) context.Context {
+	if d.userAgent == "" {
+		return ctx
+	}
+	c := *httpClient(ctx)
+	base := c.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	c.Transport = &userAgentTransport{userAgent: d.userAgent, base: base}
+	return context.WithValue(ctx, oauth2.HTTPClient, &c)
+}
+
+// Check whether an interactive Open or Token is needed to replace tok, i.e.
+// whether it is missing, or expired without a refresh token or with one
+// which can't be used anymore. A refresh is attempted in the latter case,
+// with the client configured for Exchange; the refreshed token is discarded,
+// use StoredTokenSource to keep it.
+func (d *Dialog) NeedsLogin(ctx context.Context, tok *oauth2.Token) bool {
+	if tok == nil {
+		return true
+	}
+	if tok.Valid() {
+		return false
+	}
+	if tok.RefreshToken == "" || d.config == nil {
+		return true
+	}
+	_, err := d.flowConfig().TokenSource(d.userAgentContext(ctx), tok).Token()
+	return err != nil
+}
@@ -0,0 +1,361 @@
+package oauthdialog
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// Start a token endpoint which never answers until the test ends.
+func stallingTokenServer(t *testing.T) *httptest.Server {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The request context is only cancelled once the body is read
+		io.Copy(io.Discard, r.Body)
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	t.Cleanup(func() {
+		close(release)
+		srv.Close()
+	})
+	return srv
+}
+
+func TestExchangeCancelled(t *testing.T) {
+	srv := stallingTokenServer(t)
+	conf := testConfig()
+	conf.Endpoint.TokenURL = srv.URL
+	d := New(conf)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := d.Exchange(ctx, "code")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Exchange returned after %v", elapsed)
+	}
+}
+
+func TestTokenWithExchangeContextCancelled(t *testing.T) {
+	srv := stallingTokenServer(t)
+	conf := testConfig()
+	conf.Endpoint.TokenURL = srv.URL
+	d := New(conf, WithOpener(redirectOpener(url.Values{"code": {"code"}})))
+
+	exchangeCtx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(200*time.Millisecond, cancel)
+	_, err := d.TokenWithExchangeContext(context.Background(), exchangeCtx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want context.Canceled", err)
+	}
+	var exchangeErr *ExchangeError
+	if !errors.As(err, &exchangeErr) {
+		t.Errorf("got error %T, want an *ExchangeError", err)
+	}
+}
+
+func TestExchangeTimeout(t *testing.T) {
+	expireTimers(t, time.Hour)
+	srv := stallingTokenServer(t)
+	conf := testConfig()
+	conf.Endpoint.TokenURL = srv.URL
+	d := New(conf, WithExchangeTimeout(time.Hour))
+
+	_, err := d.Exchange(context.Background(), "code")
+	if !errors.Is(err, ErrExchangeTimeout) {
+		t.Fatalf("got error %v, want ErrExchangeTimeout", err)
+	}
+}
+
+func TestExchangeTimeoutDisabled(t *testing.T) {
+	expireTimers(t, defaultExchangeTimeout)
+	srv := stallingTokenServer(t)
+	conf := testConfig()
+	conf.Endpoint.TokenURL = srv.URL
+	d := New(conf, WithExchangeTimeout(0))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := d.Exchange(ctx, "code")
+	if errors.Is(err, ErrExchangeTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got error %v, want the context's", err)
+	}
+}
+
+// Start a token endpoint answering with body, recording the posted form.
+func tokenServer(t *testing.T, body string) (*httptest.Server, *url.Values) {
+	var form url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = r.PostForm
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &form
+}
+
+func TestWithRefreshToken(t *testing.T) {
+	q := authQuery(t, New(testConfig(), WithRefreshToken()))
+	if q.Get("access_type") != "offline" || q.Get("prompt") != "consent" {
+		t.Errorf("got query %v, want access_type=offline and prompt=consent", q)
+	}
+
+	srv, _ := tokenServer(t, `{"access_token":"access","token_type":"bearer"}`)
+	conf := testConfig()
+	conf.Endpoint.TokenURL = srv.URL
+	d := New(conf, WithRefreshToken(), WithOpener(redirectOpener(url.Values{"code": {"code"}})))
+	if _, err := d.Token(context.Background()); err != ErrNoRefreshToken {
+		t.Errorf("got error %v, want ErrNoRefreshToken", err)
+	}
+}
+
+func TestWithRefreshTokenParams(t *testing.T) {
+	q := authQuery(t, New(testConfig(), WithRefreshToken(oauth2.SetAuthURLParam("prompt", "login"))))
+	if q.Get("access_type") != "" || q.Get("prompt") != "login" {
+		t.Errorf("got query %v, want only the given parameters", q)
+	}
+}
+
+func TestPKCEPublicClientExchange(t *testing.T) {
+	var form url.Values
+	var basicAuth bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = r.PostForm
+		_, _, basicAuth = r.BasicAuth()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"access","token_type":"bearer"}`))
+	}))
+	defer srv.Close()
+	conf := testConfig()
+	conf.Endpoint.TokenURL = srv.URL
+
+	var challenge string
+	redirect := redirectOpener(url.Values{"code": {"code"}})
+	d := New(conf, WithPKCE(), WithOpener(func(ctx context.Context, authURL string) error {
+		u, _ := url.Parse(authURL)
+		challenge = u.Query().Get("code_challenge")
+		return redirect(ctx, authURL)
+	}))
+	if _, err := d.Token(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha256.Sum256([]byte(form.Get("code_verifier")))
+	if want := base64.RawURLEncoding.EncodeToString(sum[:]); challenge != want {
+		t.Errorf("got code challenge %q, want %q", challenge, want)
+	}
+	if form.Get("client_id") != "id" || form.Get("code_verifier") == "" {
+		t.Errorf("got form %v, want client_id and code_verifier", form)
+	}
+	if _, ok := form["client_secret"]; ok || basicAuth {
+		t.Errorf("got a client secret, form %v, basic auth %v", form, basicAuth)
+	}
+}
+
+func TestWithEndpointParams(t *testing.T) {
+	srv, form := tokenServer(t, `{"access_token":"access","token_type":"bearer"}`)
+	conf := testConfig()
+	conf.Endpoint.TokenURL = srv.URL
+	d := New(conf, WithEndpointParams(url.Values{
+		"audience":     {"api.example"},
+		"redirect_uri": {"https://app.example/callback"},
+	}))
+
+	if _, err := d.Exchange(context.Background(), "code", oauth2.SetAuthURLParam("audience", "other.example")); err != nil {
+		t.Fatal(err)
+	}
+	if got := form.Get("audience"); got != "other.example" {
+		t.Errorf("got audience %q, want the Exchange option's", got)
+	}
+	if got := form.Get("redirect_uri"); got != "https://app.example/callback" {
+		t.Errorf("got redirect_uri %q, want the endpoint param", got)
+	}
+	if form.Get("code") != "code" || form.Get("grant_type") != "authorization_code" {
+		t.Errorf("got form %v, want the standard parameters", *form)
+	}
+
+	if _, err := d.Exchange(context.Background(), "code"); err != nil {
+		t.Fatal(err)
+	}
+	if got := form.Get("audience"); got != "api.example" {
+		t.Errorf("got audience %q, want the endpoint param", got)
+	}
+}
+
+func TestVerifierCleared(t *testing.T) {
+	srv, _ := tokenServer(t, `{"access_token":"access","token_type":"bearer"}`)
+	conf := testConfig()
+	conf.Endpoint.TokenURL = srv.URL
+
+	d := New(conf, WithPKCE(), WithOpener(redirectOpener(url.Values{"code": {"code"}})))
+	if _, err := d.OpenContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if d.verifier == "" {
+		t.Fatal("verifier cleared before the exchange")
+	}
+	if _, err := d.Exchange(context.Background(), "code"); err != nil {
+		t.Fatal(err)
+	}
+	if d.verifier != "" {
+		t.Error("verifier kept after the exchange")
+	}
+
+	if _, err := d.Token(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if d.verifier != "" {
+		t.Error("verifier kept after Token")
+	}
+
+	d = New(conf, WithPKCE(), WithOpener(redirectOpener(url.Values{"error": {"access_denied"}})))
+	if _, err := d.Token(context.Background()); !errors.Is(err, ErrAccessDenied) {
+		t.Fatalf("got error %v, want ErrAccessDenied", err)
+	}
+	if d.verifier != "" {
+		t.Error("verifier kept after a failed flow")
+	}
+}
+
+func TestWithUserAgent(t *testing.T) {
+	var userAgent string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgent = r.UserAgent()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"access","token_type":"bearer"}`))
+	}))
+	defer srv.Close()
+	conf := testConfig()
+	conf.Endpoint.TokenURL = srv.URL
+
+	if _, err := New(conf, WithUserAgent("myapp/1.0")).Exchange(context.Background(), "code"); err != nil {
+		t.Fatal(err)
+	}
+	if userAgent != "myapp/1.0" {
+		t.Errorf("got User-Agent %q, want myapp/1.0", userAgent)
+	}
+
+	// The caller's client is still used
+	var used bool
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		used = true
+		return http.DefaultTransport.RoundTrip(req)
+	})}
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, client)
+	if _, err := New(conf, WithUserAgent("myapp/1.0")).Exchange(ctx, "code"); err != nil {
+		t.Fatal(err)
+	}
+	if !used || userAgent != "myapp/1.0" {
+		t.Errorf("got User-Agent %q through the caller's client (used: %v)", userAgent, used)
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestTokenExtras(t *testing.T) {
+	srv, _ := tokenServer(t, `{"access_token":"access","token_type":"bearer","expires_in":3600,`+
+		`"id_token":"header.claims.sig","refresh_token_expires_in":86400,"custom":{"tenant":"t1"}}`)
+	conf := testConfig()
+	conf.Endpoint.TokenURL = srv.URL
+	before := time.Now()
+	tok, err := New(conf).Exchange(context.Background(), "code")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := IDToken(tok); got != "header.claims.sig" {
+		t.Errorf("got ID token %q", got)
+	}
+	if got := tok.Extra("refresh_token_expires_in"); got != float64(86400) {
+		t.Errorf("got refresh_token_expires_in %v", got)
+	}
+	if custom, _ := tok.Extra("custom").(map[string]interface{}); custom["tenant"] != "t1" {
+		t.Errorf("got custom %v", tok.Extra("custom"))
+	}
+	if issued := IssuedAt(tok); issued.Before(before.Add(-time.Second)) || issued.After(time.Now().Add(time.Second)) {
+		t.Errorf("got issued at %v, want about %v", issued, before)
+	}
+}
+
+func TestTokenExtrasForm(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-www-form-urlencoded")
+		w.Write([]byte("access_token=access&token_type=bearer&expires_in=60&id_token=id"))
+	}))
+	defer srv.Close()
+	conf := testConfig()
+	conf.Endpoint.TokenURL = srv.URL
+	tok, err := New(conf).Exchange(context.Background(), "code")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := IDToken(tok); got != "id" {
+		t.Errorf("got ID token %q", got)
+	}
+	if issued := IssuedAt(tok); time.Since(issued) > time.Minute || time.Since(issued) < -time.Second {
+		t.Errorf("got issued at %v", issued)
+	}
+}
+
+func TestTokenExtrasMissing(t *testing.T) {
+	if IDToken(nil) != "" || !IssuedAt(nil).IsZero() {
+		t.Error("got values for a nil token")
+	}
+	tok := &oauth2.Token{AccessToken: "access"}
+	if IDToken(tok) != "" || !IssuedAt(tok).IsZero() {
+		t.Error("got values for a token without extras")
+	}
+}
+
+func TestExchangeErrorLargeBody(t *testing.T) {
+	secret := strings.Repeat("SECRET", 20)
+	for name, body := range map[string]string{
+		"json": `{"error":"invalid_grant","pad":"` + strings.Repeat("x", 960) + `","access_token":"` + secret + `","tail":"` + strings.Repeat("y", 100) + `"}`,
+		"form": "error=invalid_grant&pad=" + strings.Repeat("x", 960) + "&refresh_token=" + secret + "&tail=" + strings.Repeat("y", 100),
+	} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(body))
+		}))
+		conf := testConfig()
+		conf.Endpoint.TokenURL = srv.URL
+		_, err := New(conf).Exchange(context.Background(), "code")
+		srv.Close()
+
+		var exchangeErr *ExchangeError
+		if !errors.As(err, &exchangeErr) {
+			t.Fatalf("%v: got error %v, want an *ExchangeError", name, err)
+		}
+		if exchangeErr.StatusCode != http.StatusBadRequest {
+			t.Errorf("%v: got status %v", name, exchangeErr.StatusCode)
+		}
+		if len(exchangeErr.Body) != maxErrorBodyLength {
+			t.Errorf("%v: got a body of %v bytes, want %v", name, len(exchangeErr.Body), maxErrorBodyLength)
+		}
+		if strings.Contains(exchangeErr.Body, "SECRET") || strings.Contains(err.Error(), "SECRET") {
+			t.Errorf("%v: token leaked in %q", name, exchangeErr.Body)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package oauthdialog
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+var (
+	// ErrInvalidConfig is returned by Validate when the config can't be used.
+	ErrInvalidConfig = errors.New("Invalid config")
+	// ErrNoTokenURL is returned by Validate when the config has no token
+	// endpoint. It can be ignored if the code isn't exchanged by the dialog.
+	ErrNoTokenURL = errors.New("Missing token endpoint")
+	// ErrInsecureAuthURL is returned when WithRequireHTTPS is used and the
+	// authorization URL isn't HTTPS.
+	ErrInsecureAuthURL = errors.New("Authorization URL is not HTTPS")
+)
+
+// Check that the config is usable, without side effects. A missing token
+// endpoint is reported as ErrNoTokenURL, other problems as ErrInvalidConfig.
+func (d *Dialog) Validate() error {
+	conf := d.config
+	if conf == nil {
+		return fmt.Errorf("%w: missing config", ErrInvalidConfig)
+	}
+	if conf.ClientID == "" {
+		return fmt.Errorf("%w: missing client ID", ErrInvalidConfig)
+	}
+	if err := validateEndpointURL(conf.Endpoint.AuthURL); err != nil {
+		return fmt.Errorf("%w: authorization endpoint: %v", ErrInvalidConfig, err)
+	}
+	for _, scope := range mergeScopes(conf.Scopes, d.scopes) {
+		if !validScope(scope) {
+			return fmt.Errorf("%w: malformed scope %q", ErrInvalidConfig, scope)
+		}
+	}
+	if d.responseType != nil {
+		if _, err := parseResponseType(d.responseType); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+		}
+	}
+
+	if conf.Endpoint.TokenURL == "" {
+		return ErrNoTokenURL
+	}
+	if err := validateEndpointURL(conf.Endpoint.TokenURL); err != nil {
+		return fmt.Errorf("%w: token endpoint: %v", ErrInvalidConfig, err)
+	}
+
+	return nil
+}
+
+func validateEndpointURL(s string) error {
+	if s == "" {
+		return errors.New("missing URL")
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("URL %q is not absolute", s)
+	}
+	return nil
+}
+
+// Check a scope token, as defined in RFC 6749 section 3.3.
+func validScope(scope string) bool {
+	for _, c := range []byte(scope) {
+		if c < 0x21 || c > 0x7E || c == '"' || c == '\\' {
+			return false
+		}
+	}
+	return scope != ""
+}
+
+// Check that the authorization URL is HTTPS, unless its host is allowed to
+// use plain HTTP.
+func (d *Dialog) checkHTTPS(authURL string) error {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme == "https" {
+		return nil
+	}
+	for _, host := range d.insecureHosts {
+		if u.Scheme == "http" && u.Host == host {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %v://%v", ErrInsecureAuthURL, u.Scheme, u.Host)
+}
@@ -0,0 +1,68 @@
+package oauthdialog
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+func TestValidate(t *testing.T) {
+	if err := New(testConfig()).Validate(); err != nil {
+		t.Errorf("got error %v for a valid config", err)
+	}
+
+	for name, modify := range map[string]func(conf *oauth2.Config){
+		"missing client ID":  func(conf *oauth2.Config) { conf.ClientID = "" },
+		"missing auth URL":   func(conf *oauth2.Config) { conf.Endpoint.AuthURL = "" },
+		"relative auth URL":  func(conf *oauth2.Config) { conf.Endpoint.AuthURL = "/auth" },
+		"malformed scope":    func(conf *oauth2.Config) { conf.Scopes = []string{"read write"} },
+		"relative token URL": func(conf *oauth2.Config) { conf.Endpoint.TokenURL = "token" },
+	} {
+		conf := testConfig()
+		modify(conf)
+		if err := New(conf).Validate(); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("got error %v for a %v, want ErrInvalidConfig", err, name)
+		}
+	}
+
+	if err := New(nil).Validate(); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("got error %v for a nil config, want ErrInvalidConfig", err)
+	}
+	if err := New(testConfig(), WithResponseType("code", "bogus")).Validate(); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("got error %v for an invalid response type, want ErrInvalidConfig", err)
+	}
+	conf := testConfig()
+	conf.Endpoint.TokenURL = ""
+	if err := New(conf).Validate(); err != ErrNoTokenURL {
+		t.Errorf("got error %v without a token URL, want ErrNoTokenURL", err)
+	}
+}
+
+func TestWithRequireHTTPS(t *testing.T) {
+	redirect := redirectOpener(url.Values{"code": {"code"}})
+	opened := false
+	opener := WithOpener(func(ctx context.Context, url string) error {
+		opened = true
+		return redirect(ctx, url)
+	})
+
+	conf := testConfig()
+	conf.Endpoint.AuthURL = "http://localhost:8080/auth"
+	_, err := New(conf, opener, WithRequireHTTPS()).OpenContext(context.Background())
+	if !errors.Is(err, ErrInsecureAuthURL) {
+		t.Errorf("got error %v, want ErrInsecureAuthURL", err)
+	}
+	if opened {
+		t.Error("insecure authorization URL opened")
+	}
+
+	if _, err := New(conf, opener, WithRequireHTTPS("localhost:8080")).OpenContext(context.Background()); err != nil {
+		t.Errorf("got error %v for an allowed insecure host", err)
+	}
+	if _, err := New(testConfig(), opener, WithRequireHTTPS("localhost:8080")).OpenContext(context.Background()); err != nil {
+		t.Errorf("got error %v for an HTTPS URL", err)
+	}
+}
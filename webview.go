@@ -0,0 +1,65 @@
+package oauthdialog
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrCompleted is returned by HandleRedirect when the flow has already been
+// completed.
+var ErrCompleted = errors.New("Flow already completed")
+
+// A function loading the authorization URL in a web view hosted by the
+// application, e.g. with webview, Wails or Fyne, instead of the system
+// browser.
+//
+// It must intercept the navigation to redirectURI, the config's RedirectURL,
+// without loading it, and pass the full URL navigated to, fragment included,
+// to redirect. redirect returns nil once the flow is completed, an error if
+// the URL can't complete it, e.g. because its state doesn't match, in which
+// case the web view should keep waiting for another navigation. The function
+// may return once the web view is shown or block until it is closed; ctx is
+// done when the dialog completes or is cancelled, and the web view should be
+// closed then. An error it returns fails the dialog.
+type WebViewOpener func(ctx context.Context, url, redirectURI string, redirect func(callbackURL string) error) error
+
+// Open the authorization URL with a web view opener instead of the system
+// browser. No local server is started: the redirect URI is the config's
+// RedirectURL, e.g. a custom scheme, and the redirect is captured by the web
+// view.
+func WithWebView(open WebViewOpener) Option {
+	return func(d *Dialog) {
+		d.noServer = true
+		d.opener = func(ctx context.Context, url string) error {
+			return open(ctx, url, d.RedirectURL(), d.HandleRedirect)
+		}
+	}
+}
+
+// Complete the flow in progress with the URL the provider redirected to,
+// when the redirect is captured by the application rather than the local
+// server, e.g. by a web view or a custom scheme handler. The URL is parsed
+// as ParseCallbackURL does, and the result or error is returned by Open or
+// Wait. An URL whose state isn't the one of the flow is rejected with
+// ErrStateMismatch and leaves the flow in progress.
+func (d *Dialog) HandleRedirect(callbackURL string) error {
+	f := d.currentFlow()
+	if f == nil {
+		return ErrNotStarted
+	}
+	d.mu.Lock()
+	f.received = true
+	d.mu.Unlock()
+
+	res, _, err := parseCallbackURL(callbackURL, d.callbackParams)
+	if err != nil {
+		return err
+	}
+	if !f.matches(res.State) {
+		return ErrStateMismatch
+	}
+	if !d.deliver(f, res) {
+		return ErrCompleted
+	}
+	return nil
+}